@@ -13,6 +13,7 @@ import (
 	hscommand "github.com/daboyuka/hs/hsruntime/command"
 	"github.com/daboyuka/hs/program/command"
 	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
 )
 
 func cmdBuild(cmd *cobra.Command, args []string) (finalErr error) {
@@ -41,17 +42,19 @@ func cmdBuild(cmd *cobra.Command, args []string) (finalErr error) {
 	}
 
 	scp, binds := hctx.Globals.Scope, hctx.Globals.Binds
+	scp, inputIds := scope.NewScope(scp, inputVarNames...)
 
-	hcmd, scp, err := hscommand.NewHttpBuildCommand(method, urlSrc, bodySrc, buildFlagVals.headers, scp, hctx)
+	hcmdRaw, _, err := hscommand.NewHttpBuildCommand(method, urlSrc, bodySrc, buildFlagVals.headers, newBuildOptions(inputIds), scp, hctx)
 	if err != nil {
 		return err
 	}
+	hcmd := bindInputSourceCommand{Cmd: hcmdRaw, Ids: inputIds}
 
-	input, err := openInput(os.Stdin, commonFlagVals.infmt)
+	input, err := openInputs(commonFlagVals.inputs, commonFlagVals.infmt)
 	if err != nil {
 		return err
 	}
 	sink := &record.StringWriterSink{Writer: os.Stdout}
 
-	return command.RunParallel(ctx, hcmd, binds, input, sink, 1, nil)
+	return command.RunParallel(ctx, hcmd, binds, input, sink, 1, nil, nil)
 }
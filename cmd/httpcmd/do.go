@@ -8,9 +8,9 @@ import (
 	"github.com/spf13/cobra"
 
 	cmdctx "github.com/daboyuka/hs/cmd/context"
-	"github.com/daboyuka/hs/hsruntime"
 	hscommand "github.com/daboyuka/hs/hsruntime/command"
 	"github.com/daboyuka/hs/program/command"
+	"github.com/daboyuka/hs/program/scope"
 )
 
 func cmdDo(cmd *cobra.Command, args []string) (finalErr error) {
@@ -22,7 +22,11 @@ func cmdDo(cmd *cobra.Command, args []string) (finalErr error) {
 		bodySrc = args[1]
 	}
 
-	hctx, err := cmdctx.Init(hsruntime.Options{CookieSpecs: runFlagVals.cookies}, true)
+	opts, err := newRuntimeOptions()
+	if err != nil {
+		return err
+	}
+	hctx, err := cmdctx.Init(opts, true)
 	if err != nil {
 		return err
 	}
@@ -35,32 +39,45 @@ func cmdDo(cmd *cobra.Command, args []string) (finalErr error) {
 	}
 
 	scp, binds := hctx.Globals.Scope, hctx.Globals.Binds
+	scp, inputIds := scope.NewScope(scp, inputVarNames...)
 
-	var retry hscommand.RetryFunc
-	if runFlagVals.retries > 0 {
-		retry = func(req hscommand.RequestAndBody, resp hscommand.ResponseAndBody, attempt int) (backoff time.Duration, retry bool) {
-			return time.Second, (resp.HTTPError != nil || resp.StatusCode/100 == 5) && attempt < runFlagVals.retries
-		}
+	runOpts, err := newRunOptions(hctx)
+	if err != nil {
+		return err
 	}
 
-	hcmdRaw, scp, err := hscommand.NewHttpCommand(method, urlSrc, bodySrc, buildFlagVals.headers, scp, hctx, retry)
+	hcmdRaw, _, err := hscommand.NewHttpCommand(method, urlSrc, bodySrc, buildFlagVals.headers, newBuildOptions(inputIds), runOpts, scp, hctx)
 	if err != nil {
 		return err
 	}
 
 	var hcmd command.Command = hcmdRaw
-	if runFlagVals.outfmt == "full" {
+	if runFlagVals.outfmt == "full" || len(runFlagVals.cols) > 0 {
 		hcmd = addInputFieldCommand{Cmd: hcmd, Field: "input"}
 	}
+	hcmd = bindInputSourceCommand{Cmd: hcmd, Ids: inputIds, AddFields: addSourceFields()}
 
-	input, err := openInput(os.Stdin, commonFlagVals.infmt)
+	input, err := openInputs(commonFlagVals.inputs, commonFlagVals.infmt)
+	if err != nil {
+		return err
+	}
+	input, hcmd, closeJournal, err := attachJournal(input, hcmd)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := closeJournal(); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}()
 
 	isStdoutNormalFile := isFileOutput(os.Stdout)
 
-	sink := openOutput(os.Stdout, os.Stdout, runFlagVals.outfmt)
+	cols, err := parseColumns(runFlagVals.cols, scp, hctx.Funcs)
+	if err != nil {
+		return err
+	}
+	sink := openOutput(os.Stdout, os.Stdout, runFlagVals.outfmt, runFlagVals.emit, cols, binds, inputIds, runFlagVals.srcField)
 	if fn := runFlagVals.failfile; fn != "" && fn != "-" {
 		f, err := os.Create(fn)
 		if err != nil {
@@ -73,16 +90,22 @@ func cmdDo(cmd *cobra.Command, args []string) (finalErr error) {
 		}()
 		sink.Err = f
 	}
-	defer sink.Finish()
+	defer func() {
+		if err := sink.Finish(); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}()
 
 	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancelDeadline := withDeadline(ctx)
+	defer cancelDeadline()
 
 	enableProgress := runFlagVals.progress == "true" || (runFlagVals.progress == "auto" && isStdoutNormalFile)
-	input, outCounter, awaitProgressLogger := attachProgressLogger(ctx, input, enableProgress, maxInputBufferRecords, time.Second/4, os.Stderr)
+	input, outCounter, awaitProgressLogger := attachProgressLogger(ctx, input, enableProgress, commonFlagVals.inputBuffer, spillOptions(), time.Second/4, os.Stderr)
 	defer awaitProgressLogger()
 
 	attachInterruptForHttpRunner(ctx, hcmdRaw.SetDryRun, cancel)
 
 	defer cancel()
-	return command.RunParallel(ctx, hcmd, binds, input, sink, runFlagVals.parallel, outCounter)
+	return runParallel(ctx, hcmd, binds, input, sink, outCounter)
 }
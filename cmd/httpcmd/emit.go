@@ -0,0 +1,213 @@
+package httpcmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/daboyuka/hs/program/expr"
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+var emits = []string{"lines", "jsonl", "yaml", "csv", "tsv", "table", "msgpack", "cbor"}
+
+// column is an output column, computed by evaluating an expression on each output record.
+type column struct {
+	name string
+	expr expr.Expr
+}
+
+// parseColumns parses column specs of the form "name=expr" or "expr" (in which case the name is the expression).
+func parseColumns(specs []string, scp *scope.Scope, fns *scope.FuncTable) ([]column, error) {
+	cols := make([]column, len(specs))
+	for i, spec := range specs {
+		name, src, ok := strings.Cut(spec, "=")
+		if !ok || !scope.ValidIdent(name) {
+			name, src = spec, spec
+		}
+
+		e, err := parser.ParseExpr(src, scp, fns)
+		if err != nil {
+			return nil, fmt.Errorf("bad column expression '%s': %w", src, err)
+		}
+		cols[i] = column{name: name, expr: e}
+	}
+	return cols, nil
+}
+
+func columnNames(cols []column) (names []string) {
+	for _, col := range cols {
+		names = append(names, col.name)
+	}
+	return names
+}
+
+// newColumnsFormatter returns an outputFormatter that evaluates cols on each record, producing an object of results.
+// inputIds (as created for inputVarNames, in the scope cols were parsed in) are bound to each record's input source.
+// Unless sourceFields, the input source fields are removed from each record before evaluating cols.
+func newColumnsFormatter(cols []column, binds *scope.Bindings, inputIds []scope.Ident, sourceFields bool) outputFormatter {
+	return func(rec record.Record) (record.Record, error) {
+		recBinds := bindInputSourceFields(rec, inputIds, binds)
+		if !sourceFields {
+			rec = withoutInputSourceFields(rec)
+		}
+		out := make(record.Object, len(cols))
+		for _, col := range cols {
+			v, err := col.expr.Eval(rec, recBinds)
+			if err != nil {
+				return nil, fmt.Errorf("error evaluating column '%s': %w", col.name, err)
+			}
+			out[col.name] = v
+		}
+		return out, nil
+	}
+}
+
+// recordEncoder encodes records to an output stream. It is not safe for concurrent use.
+type recordEncoder interface {
+	Encode(rec record.Record) error
+	// Flush writes any buffered output.
+	Flush() error
+}
+
+// newRecordEncoder returns a recordEncoder writing to w in format emit (one of emits). For tabular formats, colNames
+// gives the columns to output; if nil, columns are the sorted keys of the first record.
+func newRecordEncoder(w io.Writer, emit string, colNames []string) recordEncoder {
+	switch emit {
+	case "lines":
+		return funcEncoder{w: w, enc: func(rec record.Record) ([]byte, error) { return []byte(record.CoerceString(rec) + "\n"), nil }}
+	case "jsonl":
+		return funcEncoder{w: w, enc: func(rec record.Record) ([]byte, error) {
+			b, err := json.Marshal(rec)
+			return append(b, '\n'), err
+		}}
+	case "yaml":
+		return yamlEncoder{enc: yaml.NewEncoder(w)}
+	case "msgpack":
+		return funcEncoder{w: w, enc: record.MarshalMsgpack}
+	case "cbor":
+		return funcEncoder{w: w, enc: record.MarshalCBOR}
+	case "csv", "tsv":
+		enc := &csvEncoder{w: csv.NewWriter(w), cols: colNames}
+		if emit == "tsv" {
+			enc.w.Comma = '\t'
+		}
+		return enc
+	case "table":
+		return &tableEncoder{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0), cols: colNames}
+	}
+	panic(fmt.Errorf("unsupported emit '%s'", emit))
+}
+
+type funcEncoder struct {
+	w   io.Writer
+	enc func(rec record.Record) ([]byte, error)
+}
+
+func (f funcEncoder) Encode(rec record.Record) error {
+	b, err := f.enc(rec)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(b)
+	return err
+}
+
+func (f funcEncoder) Flush() error { return nil }
+
+type yamlEncoder struct{ enc *yaml.Encoder }
+
+func (y yamlEncoder) Encode(rec record.Record) error { return y.enc.Encode(rec) }
+func (y yamlEncoder) Flush() error                   { return y.enc.Close() }
+
+// tabularRow converts rec to cells for columns cols, choosing cols from rec first if not yet set.
+// A non-object record is a single column "value".
+func tabularRow(rec record.Record, cols *[]string) (cells []string) {
+	obj, isObj := rec.(record.Object)
+	if *cols == nil {
+		if !isObj {
+			*cols = []string{"value"}
+		} else {
+			*cols = make([]string, 0, len(obj))
+			for k := range obj {
+				*cols = append(*cols, k)
+			}
+			slices.Sort(*cols)
+		}
+	}
+	if !isObj {
+		obj = record.Object{"value": rec}
+	}
+
+	cells = make([]string, len(*cols))
+	for i, col := range *cols {
+		if v := obj[col]; v != nil {
+			cells[i] = record.CoerceString(v)
+		}
+	}
+	return cells
+}
+
+type csvEncoder struct {
+	w          *csv.Writer
+	cols       []string
+	wroteFirst bool
+}
+
+func (c *csvEncoder) Encode(rec record.Record) error {
+	cells := tabularRow(rec, &c.cols)
+	if !c.wroteFirst {
+		c.wroteFirst = true
+		if err := c.w.Write(c.cols); err != nil {
+			return err
+		}
+	}
+	if err := c.w.Write(cells); err != nil {
+		return err
+	}
+	c.w.Flush() // keep output streaming
+	return c.w.Error()
+}
+
+func (c *csvEncoder) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tableEncoder writes aligned columns. Since column widths depend on all rows, output is buffered until Flush.
+type tableEncoder struct {
+	w          *tabwriter.Writer
+	cols       []string
+	wroteFirst bool
+}
+
+var tableCellReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+func (t *tableEncoder) writeRow(cells []string) error {
+	for i, cell := range cells {
+		cells[i] = tableCellReplacer.Replace(cell)
+	}
+	_, err := io.WriteString(t.w, strings.Join(cells, "\t")+"\n")
+	return err
+}
+
+func (t *tableEncoder) Encode(rec record.Record) error {
+	cells := tabularRow(rec, &t.cols)
+	if !t.wroteFirst {
+		t.wroteFirst = true
+		if err := t.writeRow(slices.Clone(t.cols)); err != nil {
+			return err
+		}
+	}
+	return t.writeRow(cells)
+}
+
+func (t *tableEncoder) Flush() error { return t.w.Flush() }
@@ -0,0 +1,183 @@
+package httpcmd
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+func TestColumnsInputSource(t *testing.T) {
+	scp, ids := scope.NewScope(nil, inputVarNames...)
+	cols, err := parseColumns([]string{"file=input_file", "line=input_line", "status=.response.status", "field=.input_file"}, scp, scope.NewFuncTable(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := newColumnsFormatter(cols, nil, ids, false)
+	formatFields := newColumnsFormatter(cols, nil, ids, true) // as with --source-fields
+
+	cmd := bindInputSourceCommand{Cmd: respondCommand{}, Ids: ids, AddFields: true}
+	for _, in := range sourced("a", "fail") {
+		out, _, err := cmd.Run(context.Background(), in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := out.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := in.(sourcedRecord)
+		if obj := rec.(record.Object); obj["input_file"] != src.file || obj["input_line"] != src.line {
+			t.Errorf("input %v: expected source fields %s:%v, got %v", src.rec, src.file, src.line, obj)
+		}
+		status := rec.(record.Object)["response"].(record.Object)["status"]
+
+		// The source fields are only visible to column expressions with --source-fields
+		got, err := format(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := record.Object{"file": src.file, "line": src.line, "status": status, "field": nil}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("input %v: expected columns %v, got %v", src.rec, expect, got)
+		}
+		if got, err = formatFields(rec); err != nil {
+			t.Fatal(err)
+		}
+		expect["field"] = src.file
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("input %v: with source fields, expected columns %v, got %v", src.rec, expect, got)
+		}
+	}
+
+	// Records without source fields bind null
+	if got, err := format(record.Object{"response": record.Object{"status": 200.0}}); err != nil {
+		t.Fatal(err)
+	} else if expect := (record.Object{"file": nil, "line": nil, "status": 200.0, "field": nil}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("no source: expected columns %v, got %v", expect, got)
+	}
+}
+
+// encodeAll encodes recs with a new recordEncoder for emit and cols, returning the output.
+func encodeAll(t *testing.T, emit string, cols []string, recs ...record.Record) string {
+	t.Helper()
+	var buf strings.Builder
+	enc := newRecordEncoder(&buf, emit, cols)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.String()
+}
+
+func TestTabularEncoders(t *testing.T) {
+	recs := []record.Record{
+		record.Object{"a": "x,y", "b": "say \"hi\"", "c": 1.0},
+		record.Object{"a": "line1\nline2", "b": "tab\there", "d": "not a column"},
+		record.Object{"a": nil, "b": record.Array{1.0, "z"}, "c": true},
+	}
+	tests := []struct {
+		Emit   string
+		Cols   []string
+		Recs   []record.Record
+		Expect string
+	}{
+		{
+			Emit:   "csv",
+			Recs:   recs,
+			Expect: "a,b,c\n\"x,y\",\"say \"\"hi\"\"\",1\n\"line1\nline2\",tab\there,\n,\"[1,\"\"z\"\"]\",true\n",
+		},
+		{
+			Emit:   "tsv",
+			Recs:   recs,
+			Expect: "a\tb\tc\nx,y\t\"say \"\"hi\"\"\"\t1\n\"line1\nline2\"\t\"tab\there\"\t\n\t\"[1,\"\"z\"\"]\"\ttrue\n",
+		},
+		{
+			Emit:   "csv",
+			Cols:   []string{"d", "a"},
+			Recs:   recs,
+			Expect: "d,a\n,\"x,y\"\nnot a column,\"line1\nline2\"\n,\n",
+		},
+		{
+			Emit:   "csv",
+			Recs:   []record.Record{"s", 2.0},
+			Expect: "value\ns\n2\n",
+		},
+		{
+			Emit:   "table",
+			Recs:   recs,
+			Expect: "a            b         c\nx,y          say \"hi\"  1\nline1 line2  tab here  \n             [1,\"z\"]   true\n",
+		},
+	}
+	for _, tst := range tests {
+		if got := encodeAll(t, tst.Emit, tst.Cols, tst.Recs...); got != tst.Expect {
+			t.Errorf("test '%s' %v: got\n%s\nexpect\n%s", tst.Emit, tst.Cols, got, tst.Expect)
+		}
+	}
+}
+
+func TestTableEncoderBuffers(t *testing.T) {
+	var buf strings.Builder
+	enc := newRecordEncoder(&buf, "table", nil)
+	if err := enc.Encode(record.Object{"a": "x", "bb": "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no output before Flush, got %q", buf.String())
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	} else if expect := "a  bb\nx  y\n"; buf.String() != expect {
+		t.Errorf("got %q, expect %q", buf.String(), expect)
+	}
+}
+
+func TestYAMLEncoder(t *testing.T) {
+	got := encodeAll(t, "yaml", nil, record.Object{"a": 1.0, "b": record.Array{"x"}}, "s", nil)
+	if expect := "a: 1\nb:\n    - x\n---\ns\n---\nnull\n"; got != expect {
+		t.Errorf("got\n%s\nexpect\n%s", got, expect)
+	}
+}
+
+func TestColumnsErrorRecords(t *testing.T) {
+	cols, err := parseColumns([]string{"id=.input.id", "status=.response.status", "error=.response.error"}, nil, scope.NewFuncTable(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, fails strings.Builder
+	sink := openOutput(&out, &fails, "auto", "csv", cols, nil, nil, false)
+
+	// Fail responses are written to the fails output as columns, like other responses
+	recs := []record.Record{
+		record.Object{"input": record.Object{"id": 1.0}, "response": record.Object{"status": 200.0}},
+		record.Object{"input": record.Object{"id": 2.0}, "response": record.Object{"status": 503.0}},
+		record.Object{"input": record.Object{"id": 3.0}, "response": record.Object{"error": "connection refused"}},
+	}
+	for _, rec := range recs {
+		if err := sink.Sink(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Error records (see --on-error emit) are written as-is, filling the columns named as their fields
+	if err := sink.SinkError(record.Object{"input": "bad", "error": "input error: bad JSON", "stage": "input"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "id,status,error\n1,200,\n"; out.String() != expect {
+		t.Errorf("output: got\n%s\nexpect\n%s", out.String(), expect)
+	}
+	if expect := "id,status,error\n2,503,\n3,,connection refused\n,,input error: bad JSON\n"; fails.String() != expect {
+		t.Errorf("fails output: got\n%s\nexpect\n%s", fails.String(), expect)
+	}
+}
@@ -1,20 +1,28 @@
 package httpcmd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"golang.org/x/term"
 
 	"github.com/daboyuka/hs/cmd/flagvar"
+	"github.com/daboyuka/hs/hsruntime"
+	hscommand "github.com/daboyuka/hs/hsruntime/command"
 	"github.com/daboyuka/hs/hsruntime/datafmt"
+	"github.com/daboyuka/hs/program/command"
 	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
 )
 
 var Group = &cobra.Group{ID: "http", Title: "HTTP request commands"}
@@ -23,13 +31,28 @@ var Commands []*cobra.Command
 var (
 	commonFlags    pflag.FlagSet
 	commonFlagVals struct {
-		infmt string
+		inputs      []string
+		inputBuffer int
+		inputSpill  bool
+		infmt       string
+		each        string
+		delim       string
+		keepEmpty   bool
+		xmlRecord   string
+		csvTypes    []string
+		csvInfer    bool
+		csvRename   []string
+		csvSkip     []string
+		csvRagged   bool
 	}
 
 	buildFlags    pflag.FlagSet
 	buildFlagVals struct {
 		headers   []string
+		form      []string
 		loadSpecs []string
+		bodyfmt   string
+		idemKey   bool
 	}
 
 	runFlags    pflag.FlagSet
@@ -37,9 +60,54 @@ var (
 		cookies  []string
 		failfile string
 		outfmt   string
+		emit     string
+		cols     []string
+		srcField bool
 		parallel int
+		ordered  bool
+		rate     string
+
+		timeout        time.Duration
+		connectTimeout time.Duration
+		tlsTimeout     time.Duration
+		headerTimeout  time.Duration
+		deadline       time.Duration
+
+		http2           string
+		maxConnsPerHost int
+		maxIdleConns    int
+		maxIdlePerHost  int
+		idleTimeout     time.Duration
+		keepAlive       time.Duration
+		noKeepAlive     bool
+
+		caFiles     []string
+		clientCerts []string
+		tlsMin      string
+		sni         string
+		insecure    bool
+
+		proxy   string
+		noProxy []string
+
+		onError  string
 		progress string
 		retries  int
+		journal  string
+		saveBody string
+
+		acceptEncoding string
+		compressBody   string
+		auth           string
+
+		retryDelay    time.Duration
+		retryBackoff  float64
+		retryMaxDelay time.Duration
+		retryJitter   float64
+		retryStatus   string
+		retryAfter    bool
+		retryUnsafe   bool
+		retryIf       string
 	}
 )
 
@@ -49,17 +117,43 @@ var allMethods = []string{
 }
 
 func init() {
-	infmts := []string{"auto", "null", "raw", "lines", "json", "csv", "rawcsv", "tsv", "rawtsv"}
+	infmts := []string{"auto", "null", "raw", "lines", "lines0", "json", "yaml", "xml", "msgpack", "cbor", "csv", "rawcsv", "tsv", "rawtsv"}
+	commonFlags.StringArrayVar(&commonFlagVals.inputs, "input", nil, "read input records from this file, or stdin if '-' (the default); may be a glob pattern; flag may be repeated\n"+
+		"files are read in order, each with its own autodetected format (with -i auto)")
+	commonFlags.IntVar(&commonFlagVals.inputBuffer, "input-buffer", maxInputBufferRecords, "with progress meter, max. input records to read ahead in memory (to find the total)")
+	commonFlags.BoolVar(&commonFlagVals.inputSpill, "input-spill", false, "with progress meter, read ahead all input, holding records beyond --input-buffer in a temporary file")
 	commonFlagVals.infmt = "auto" // default
 	_ = commonFlags.VarPF(flagvar.NewEnumFlag(&commonFlagVals.infmt, false, infmts...), "in", "i", "set input mode (one of "+strings.Join(infmts, " ")+")")
+	commonFlags.StringVar(&commonFlagVals.delim, "delim", "", "for lines input mode (implied by default), split records on this delimiter instead of newline;\n"+
+		"Go string escapes are interpreted (e.g. '\\t', '\\x00')")
+	commonFlags.BoolVar(&commonFlagVals.keepEmpty, "keep-empty", false, "for lines/lines0 input modes, read empty lines as empty-string records, rather than skipping them")
+	commonFlags.StringVar(&commonFlagVals.each, "each", "", "for json input mode, read as records the values selected by this path in each input value, streaming\n"+
+		"(e.g. .items[] for each element of field items); path steps: .name, [\"name\"], [N] (array index), [] (each element or value)")
+	commonFlags.StringSliceVar(&commonFlagVals.csvTypes, "csv-types", nil, "for csv/tsv input modes, set column types, as 'col=type,...' (column number for raw modes); types: string number int bool auto\n"+
+		"(auto = infer each value as null, bool, number or string); number/int/bool/auto convert empty values to null; flag may be repeated")
+	commonFlags.BoolVar(&commonFlagVals.csvInfer, "csv-infer", false, "for csv/tsv input modes, infer types of columns not given by --csv-types (as type auto)")
+	commonFlags.StringSliceVar(&commonFlagVals.csvRename, "csv-rename", nil, "for csv/tsv input modes, rename columns, as 'col=newname,...'; flag may be repeated")
+	commonFlags.StringSliceVar(&commonFlagVals.csvSkip, "csv-skip", nil, "for csv/tsv input modes, omit columns, as 'col,...'; flag may be repeated")
+	commonFlags.BoolVar(&commonFlagVals.csvRagged, "csv-ragged", false, "for csv/tsv input modes, allow rows with more or fewer fields than the header (missing fields are null, extra fields are dropped)")
+	commonFlags.StringVar(&commonFlagVals.xmlRecord, "xml-record", record.DefaultXMLRecordPath, "for xml input mode, path of elements to read as records (e.g. //item for all 'item' elements)")
 }
 
 func init() {
 	buildFlags.StringArrayVarP(&buildFlagVals.headers, "header", "H", nil, "add an HTTP request header; flag may be repeated")
+	buildFlags.StringArrayVarP(&buildFlagVals.form, "form", "f", nil, "add a multipart/form-data body field, as 'name=value', or 'name=@path' to upload a file\n"+
+		"(value/path are templates; '@@' begins a literal '@'); flag may be repeated; not allowed with a body argument")
 	buildFlags.StringArrayVarP(&buildFlagVals.loadSpecs, "loadjson", "L", nil, "load a JSON file as a lookup table; argument has syntax \"filename,varname,keyexpr\"\n"+
 		"filename = file to load, varname = variable to load into (as an object record),\n"+
 		"keyexpr = expression to extract the key for each loaded value, to store it as an entry in varname")
 
+	bodyfmts := []string{"auto", "json", "form", "msgpack", "cbor"}
+	buildFlagVals.bodyfmt = "auto" // default
+	_ = buildFlags.VarPF(flagvar.NewEnumFlag(&buildFlagVals.bodyfmt, false, bodyfmts...), "body-fmt", "", "set request body format, and Content-Type if not given by -H (one of: "+strings.Join(bodyfmts, " ")+")\n"+
+		"auto = autodetect Content-Type from the first body; msgpack/cbor = body must be JSON, which is encoded when sent")
+	buildFlags.BoolVar(&buildFlagVals.idemKey, "idempotency-key", false, "add an Idempotency-Key header to each request, unless given by -H, with a key derived from the method, URL,\n"+
+		"input record and its source (file and line), so the same key is sent on each retry and when rerunning the same input\n"+
+		"(e.g. resuming with --journal), allowing a request to be retried with any method (see --retry-non-idempotent)")
+
 }
 
 func init() {
@@ -68,19 +162,90 @@ func init() {
 		"Otherwise, the argument is a cookiejar filename to read, in Netscape format.",
 	)
 
-	runFlags.StringVarP(&runFlagVals.failfile, "fails", "F", "-", "write fail responses (connection error or non-2xx response) to this file, or stdout if '-'")
+	runFlags.StringVarP(&runFlagVals.failfile, "fails", "F", "-", "write fail responses (connection error, non-2xx response, or undecodable binary body) to this file, or stdout if '-'")
 
 	outfmts := []string{"auto", "full", "reqresp", "resp", "bodycode", "body"}
 	runFlagVals.outfmt = "auto" // default
 	_ = runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.outfmt, false, outfmts...), "out", "o", "set output mode (one of: "+strings.Join(outfmts, " ")+")")
 
-	runFlags.IntVarP(&runFlagVals.parallel, "parallel", "P", 1, "request parallelism (no request ordering guaranteed when greater than 1)")
+	runFlagVals.emit = "lines" // default
+	_ = runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.emit, false, emits...), "emit", "", "set output encoding (one of: "+strings.Join(emits, " ")+")\n"+
+		"lines = one output per line, strings as-is and other values as JSON; jsonl = one JSON value per line;\n"+
+		"yaml = YAML documents; csv/tsv = header and one row per output; table = aligned columns (printed at end);\n"+
+		"msgpack/cbor = concatenated binary values")
+	runFlags.StringArrayVar(&runFlagVals.cols, "col", nil, "output a column, as 'name=expr' or 'expr', evaluated on the full output record (as with -o full),\n"+
+		"with input_file and input_line bound to its input source, instead of using -o; csv/tsv/table columns default to\n"+
+		"all fields of the first output; flag may be repeated")
+	runFlags.BoolVar(&runFlagVals.srcField, "source-fields", false, "with -o full or --col, add fields input_file and input_line to each output record, giving the source\n"+
+		"of its input record (as bound to the variables of the same names)")
+
+	runFlags.IntVarP(&runFlagVals.parallel, "parallel", "P", 1, "request parallelism (no request ordering guaranteed when greater than 1, unless --ordered)")
+	runFlags.BoolVar(&runFlagVals.ordered, "ordered", false, "with -P, output in input order (outputs are buffered until those of all earlier inputs are written)")
+	runFlags.StringVar(&runFlagVals.rate, "rate", "", "limit the rate of requests (including retries) to this, as 'N/period' (e.g. 50/s, 1000/h, 1/500ms);\n"+
+		"requests are evenly spaced; see also config RATE_LIMITS for per-host limits")
+
+	onErrors := []string{"abort", "skip", "emit"}
+	runFlagVals.onError = "abort" // default
+	_ = runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.onError, false, onErrors...), "on-error", "", "on an error processing an input record (e.g. malformed input, template error, bad URL), one of:\n"+
+		"abort = stop the run; skip = skip the record; emit = write an error record {\"input\",\"error\",\"stage\"} to the fails output (-F), and continue")
 
 	progressOpts := []string{"true", "false", "auto"}
 	runFlagVals.progress = "auto" // default
 	runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.progress, false, progressOpts...), "progress", "p", "toggle progress meter (one of: "+strings.Join(progressOpts, " ")+"); auto = only if stdout is non-term redirected")
 
-	runFlags.IntVarP(&runFlagVals.retries, "retry", "r", 0, "num. retries on HTTP error or 5xx response")
+	runFlags.DurationVar(&runFlagVals.timeout, "timeout", 0, "max. time for each request attempt, including reading the response (0 = no limit);\n"+
+		"a timed out request's response is an error, with \"timeout\": true (and is retried per -r)")
+	runFlags.DurationVar(&runFlagVals.connectTimeout, "connect-timeout", 30*time.Second, "max. time to establish a connection (0 = no limit)")
+	runFlags.DurationVar(&runFlagVals.tlsTimeout, "tls-timeout", 10*time.Second, "max. time for a TLS handshake (0 = no limit)")
+	runFlags.DurationVar(&runFlagVals.headerTimeout, "header-timeout", 0, "max. time to await response headers once a request is sent (0 = no limit)")
+	runFlags.DurationVar(&runFlagVals.deadline, "deadline", 0, "max. time for the whole run (0 = no limit); when reached, in-flight requests are cancelled,\n"+
+		"and the run stops with an error")
+
+	_ = runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.http2, false, hsruntime.HTTP2Modes...), "http2", "", "HTTP/2 mode (one of: "+strings.Join(hsruntime.HTTP2Modes, " ")+"; default auto):\n"+
+		"auto = HTTP/2 if the server supports it (via TLS), else HTTP/1.1; off = HTTP/1.1 only;\n"+
+		"force = HTTP/2 only (h2c for http URLs); h2c = as auto, but cleartext HTTP/2 (prior knowledge) for http URLs;\n"+
+		"connections made with force/h2c are multiplexed, one per host: of the pool settings, only --idle-timeout,\n"+
+		"--tcp-keepalive, --no-keepalive and --max-conns-per-host (any limit = one connection) apply; --max-idle-* are an error with force")
+	runFlags.IntVar(&runFlagVals.maxConnsPerHost, "max-conns-per-host", 0, "max. connections per host, including those in use (0 = no limit); requests beyond wait for a connection")
+	runFlags.IntVar(&runFlagVals.maxIdleConns, "max-idle-conns", 0, "max. idle (keep-alive) connections, across all hosts (0 = 100)")
+	runFlags.IntVar(&runFlagVals.maxIdlePerHost, "max-idle-per-host", 0, "max. idle (keep-alive) connections per host (0 = the greater of 2 and -P)")
+	runFlags.DurationVar(&runFlagVals.idleTimeout, "idle-timeout", 0, "close idle connections after this long (0 = 90s)")
+	runFlags.DurationVar(&runFlagVals.keepAlive, "tcp-keepalive", 0, "TCP keep-alive probe interval (0 = 30s; negative disables probes)")
+	runFlags.BoolVar(&runFlagVals.noKeepAlive, "no-keepalive", false, "disable HTTP keep-alive: use a new connection for each request")
+
+	runFlags.StringArrayVar(&runFlagVals.caFiles, "cacert", nil, "trust the CA certificates in this PEM file, in addition to the system's; flag may be repeated")
+	runFlags.StringArrayVar(&runFlagVals.clientCerts, "cert", nil, "use a client certificate (mTLS), as '[host=]certfile[,keyfile]' (keyfile defaults to certfile);\n"+
+		"host is a hostname glob (e.g. '*.internal'), or all hosts if omitted; the first matching is used; flag may be repeated")
+	_ = runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.tlsMin, false, hsruntime.TLSVersions...), "tls-min", "", "min. TLS version (one of: "+strings.Join(hsruntime.TLSVersions, " ")+"; default 1.2)")
+	runFlags.StringVar(&runFlagVals.sni, "sni", "", "send this server name (SNI) in TLS handshakes, and verify server certificates against it, for all hosts")
+	runFlags.BoolVarP(&runFlagVals.insecure, "insecure", "k", false, "do not verify server certificates (insecure)")
+
+	runFlags.StringVar(&runFlagVals.proxy, "proxy", "", "send all requests via this proxy, as a URL with scheme http, https, socks5 or socks5h (default http),\n"+
+		"and optionally user:pass@ for proxy auth; 'direct' for no proxy (default: per config PROXIES, else HTTP(S)_PROXY)")
+	runFlags.StringSliceVar(&runFlagVals.noProxy, "no-proxy", nil, "connect to hosts matching these globs (e.g. '*.internal') directly, without a proxy; '*' for all hosts;\n"+
+		"flag may be repeated, or given a comma-separated list")
+
+	runFlags.IntVarP(&runFlagVals.retries, "retry", "r", 0, "num. retries on connection error or a response with a --retry-status status (or matching --retry-if)")
+	runFlags.DurationVar(&runFlagVals.retryDelay, "retry-delay", time.Second, "delay before the first retry")
+	runFlags.Float64Var(&runFlagVals.retryBackoff, "retry-backoff", 1, "factor by which the retry delay grows with each retry (e.g. 2 for exponential backoff; 1 = constant)")
+	runFlags.DurationVar(&runFlagVals.retryMaxDelay, "retry-max-delay", 0, "max. retry delay, including that requested by Retry-After (0 = no max.)")
+	runFlags.Float64Var(&runFlagVals.retryJitter, "retry-jitter", 0, "max. fraction of each retry delay to randomly add or subtract (e.g. 0.2 for +/-20%)")
+	runFlags.StringVar(&runFlagVals.retryStatus, "retry-status", "5xx", "response statuses to retry, as a comma-separated list of codes, ranges and classes (e.g. 429,500-504,5xx)")
+	runFlags.BoolVar(&runFlagVals.retryUnsafe, "retry-non-idempotent", false, "also retry requests with non-idempotent methods (POST, PATCH, etc.), which may cause duplicates;\n"+
+		"otherwise, these are retried only if they have an Idempotency-Key header (see --idempotency-key)")
+	runFlags.BoolVar(&runFlagVals.retryAfter, "retry-after", true, "wait at least as long as requested by a Retry-After response header before retrying")
+	runFlags.StringVar(&runFlagVals.retryIf, "retry-if", "", "also retry if this expression is true (not null or false), evaluated on the full output record (as with -o full);\n"+
+		"e.g. '.response.headers[\"X-Retry\"]', to retry responses with that header")
+	runFlags.StringVar(&runFlagVals.saveBody, "save-body", "", "stream each 2xx response body to a file at this path, a template evaluated on the input record\n"+
+		"(e.g. 'out/${.id}.bin'); the response has body_file, body_bytes and body_sha256 instead of body")
+	runFlags.StringVar(&runFlagVals.acceptEncoding, "accept-encoding", strings.Join(hscommand.ContentEncodings, ","), "response content encodings to accept (and decode), as a comma-separated list\n"+
+		"of: "+strings.Join(hscommand.ContentEncodings, " ")+" (empty = uncompressed only); sent as Accept-Encoding, unless given by -H")
+	_ = runFlags.VarPF(flagvar.NewEnumFlag(&runFlagVals.compressBody, false, hscommand.RequestContentEncodings...), "compress-body", "", "compress request bodies with this content encoding (one of: "+strings.Join(hscommand.RequestContentEncodings, " ")+"),\n"+
+		"setting Content-Encoding; requests with a Content-Encoding header are sent as-is")
+	runFlags.StringVar(&runFlagVals.auth, "auth", "", "authenticate requests, as 'basic:user:pass', 'bearer:token' or 'digest:user:pass' (credentials templated,\n"+
+		"e.g. 'bearer:${TOKEN}'); overrides config AUTH; requests with an Authorization header (e.g. by -H) are sent as-is")
+	runFlags.StringVar(&runFlagVals.journal, "journal", "", "record completed input records in this file, and skip those already recorded, so an interrupted run\n"+
+		"may be resumed by rerunning with the same journal (records with connection errors are not recorded)")
 }
 
 func init() {
@@ -132,12 +297,114 @@ func init() {
 	}
 }
 
+// newRuntimeOptions returns options for the runtime context per flags.
+func newRuntimeOptions() (hsruntime.Options, error) {
+	clientCerts := make([]hsruntime.ClientCert, len(runFlagVals.clientCerts))
+	for i, spec := range runFlagVals.clientCerts {
+		var err error
+		if clientCerts[i], err = hsruntime.ParseClientCert(spec); err != nil {
+			return hsruntime.Options{}, err
+		}
+	}
+
+	return hsruntime.Options{
+		CookieSpecs: runFlagVals.cookies,
+		Rate:        runFlagVals.rate,
+		Timeouts: hsruntime.Timeouts{
+			Request:        runFlagVals.timeout,
+			Connect:        runFlagVals.connectTimeout,
+			TLSHandshake:   runFlagVals.tlsTimeout,
+			ResponseHeader: runFlagVals.headerTimeout,
+		},
+		Transport: hsruntime.TransportOptions{
+			HTTP2:               runFlagVals.http2,
+			MaxConnsPerHost:     runFlagVals.maxConnsPerHost,
+			MaxIdleConns:        runFlagVals.maxIdleConns,
+			MaxIdleConnsPerHost: runFlagVals.maxIdlePerHost,
+			IdleConnTimeout:     runFlagVals.idleTimeout,
+			KeepAlive:           runFlagVals.keepAlive,
+			DisableKeepAlives:   runFlagVals.noKeepAlive,
+			Parallel:            runFlagVals.parallel,
+		},
+		TLS: hsruntime.TLSOptions{
+			CAFiles:     runFlagVals.caFiles,
+			ClientCerts: clientCerts,
+			MinVersion:  runFlagVals.tlsMin,
+			ServerName:  runFlagVals.sni,
+			Insecure:    runFlagVals.insecure,
+		},
+		Proxy: hsruntime.ProxyOptions{
+			Proxy:   runFlagVals.proxy,
+			NoProxy: runFlagVals.noProxy,
+		},
+	}, nil
+}
+
+var errDeadline = fmt.Errorf("%w (--deadline)", hscommand.ErrDeadline)
+
+// withDeadline applies --deadline, if set, to ctx: once it passes, ctx is cancelled (cancelling in-flight requests), and
+// input streams wrapped by runParallel return an error.
+func withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if runFlagVals.deadline <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, runFlagVals.deadline, errDeadline)
+}
+
+// deadlineStream ends Stream with errDeadline once ctx has passed the --deadline.
+type deadlineStream struct {
+	record.Stream
+	ctx context.Context
+}
+
+func (d deadlineStream) Next() (record.Record, error) {
+	if context.Cause(d.ctx) == errDeadline {
+		return nil, errDeadline
+	}
+	rec, err := d.Stream.Next()
+	if err == io.EOF && context.Cause(d.ctx) == errDeadline { // input may have ended early due to the deadline
+		return nil, errDeadline
+	}
+	return rec, err
+}
+
+// runParallel runs hcmd on each input per the -P, --ordered, --on-error and --deadline flags.
+func runParallel(ctx context.Context, hcmd command.Command, binds *scope.Bindings, input record.Stream, sink *responseSplitFileSink, counter *atomic.Uint64) error {
+	onError := newErrorHandler(sink)
+	input = deadlineStream{Stream: input, ctx: ctx}
+	if runFlagVals.ordered {
+		window := runFlagVals.parallel * orderedWindowPerWorker
+		return command.RunParallelOrdered(ctx, hcmd, binds, input, sink, runFlagVals.parallel, window, onError, counter)
+	}
+	return command.RunParallel(ctx, hcmd, binds, input, sink, runFlagVals.parallel, onError, counter)
+}
+
+// newErrorHandler returns the command.ErrorHandler for the --on-error flag, reporting errors to sink.
+func newErrorHandler(sink *responseSplitFileSink) command.ErrorHandler {
+	switch runFlagVals.onError {
+	case "skip":
+		return func(stage string, in record.Record, err error) error {
+			sink.SkipError()
+			return nil
+		}
+	case "emit":
+		return func(stage string, in record.Record, err error) error {
+			errRec := record.Object{"input": in, "error": err.Error(), "stage": stage}
+			if src, ok := in.(sourcedRecord); ok {
+				errRec["input"], errRec["input_file"], errRec["input_line"] = src.rec, src.file, src.line
+			}
+			return sink.SinkError(errRec)
+		}
+	}
+	return nil // abort
+}
+
 func isFailResponse(rec record.Record) bool {
 	recObj, _ := rec.(record.Object)
 	respObj, _ := recObj["response"].(record.Object)
-	errVal := respObj["error"]
+	errVal, bodyErrVal := respObj["error"], respObj["body_error"]
 	status, _ := respObj["status"].(float64)
-	return errVal != nil || int(status)/100 != 2
+	return errVal != nil || bodyErrVal != nil || int(status)/100 != 2
 }
 
 func openInput(r io.Reader, infmt string) (parsed record.Stream, err error) {
@@ -145,35 +412,103 @@ func openInput(r io.Reader, infmt string) (parsed record.Stream, err error) {
 	if infmt == "" || infmt == "auto" {
 		if f, ok := r.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
 			infmt = "null"
-		} else {
-			infmt, r, err = autoInputFormat(r)
-			if err != nil {
-				return nil, err
-			}
 		}
 	}
+	if infmt == "null" {
+		return &record.SingletonStream{Rec: nil}, nil
+	}
+
+	if infmt != "raw" { // raw input is taken as-is
+		if r, err = datafmt.DecompressReader(r); err != nil {
+			return nil, err
+		}
+	}
+	if infmt == "" || infmt == "auto" {
+		if commonFlagVals.each != "" {
+			infmt = "json"
+		} else if commonFlagVals.delim != "" {
+			infmt = "lines"
+		} else if infmt, r, err = autoInputFormat(r); err != nil {
+			return nil, err
+		}
+	}
+	if commonFlagVals.each != "" && infmt != "json" {
+		return nil, fmt.Errorf("--each requires json input mode")
+	} else if commonFlagVals.delim != "" && infmt != "lines" {
+		return nil, fmt.Errorf("--delim requires lines input mode")
+	}
 
 	switch infmt {
-	case "null":
-		return &record.SingletonStream{Rec: nil}, nil
 	case "raw":
 		return record.NewRawStream(r), nil
-	case "lines":
-		return record.NewLineStream(r), nil
+	case "lines", "lines0":
+		opts := record.LineOptions{KeepEmpty: commonFlagVals.keepEmpty}
+		if infmt == "lines0" {
+			opts.Delim = "\x00"
+		} else if commonFlagVals.delim != "" {
+			if opts.Delim, err = strconv.Unquote(`"` + strings.ReplaceAll(commonFlagVals.delim, `"`, `\"`) + `"`); err != nil {
+				return nil, fmt.Errorf("bad --delim '%s': %w", commonFlagVals.delim, err)
+			}
+		}
+		return record.NewLineStream(r, opts), nil
 	case "json":
+		if commonFlagVals.each != "" {
+			return record.NewJSONEachStream(r, commonFlagVals.each)
+		}
 		return record.NewJSONStream(r), nil
-	case "rawcsv":
-		return record.NewCsvReader(r, ',', true, false), nil
-	case "csv":
-		return record.NewCsvReader(r, ',', false, false), nil
-	case "rawtsv":
-		return record.NewCsvReader(r, '\t', true, false), nil
-	case "tsv":
-		return record.NewCsvReader(r, '\t', false, false), nil
+	case "yaml":
+		return record.NewYAMLStream(r), nil
+	case "msgpack":
+		return record.NewMsgpackStream(r), nil
+	case "cbor":
+		return record.NewCBORStream(r), nil
+	case "xml":
+		return record.NewXMLStream(r, commonFlagVals.xmlRecord)
+	case "rawcsv", "csv", "rawtsv", "tsv":
+		opts, err := newCsvOptions()
+		if err != nil {
+			return nil, err
+		}
+		comma := ','
+		if strings.HasSuffix(infmt, "tsv") {
+			comma = '\t'
+		}
+		return record.NewCsvReader(r, comma, strings.HasPrefix(infmt, "raw"), false, opts), nil
 	}
 	return nil, fmt.Errorf("unsupported infmt '%s'", infmt)
 }
 
+func newCsvOptions() (opts record.CsvOptions, err error) {
+	opts.Infer, opts.Skip, opts.Ragged = commonFlagVals.csvInfer, commonFlagVals.csvSkip, commonFlagVals.csvRagged
+
+	for _, spec := range commonFlagVals.csvTypes {
+		col, typName, ok := strings.Cut(spec, "=")
+		if !ok {
+			return opts, fmt.Errorf("bad --csv-types entry '%s', should be of form 'col=type'", spec)
+		}
+		typ, err := record.ParseCsvType(typName)
+		if err != nil {
+			return opts, err
+		}
+		if opts.Types == nil {
+			opts.Types = make(map[string]record.CsvType)
+		}
+		opts.Types[col] = typ
+	}
+
+	for _, spec := range commonFlagVals.csvRename {
+		col, newName, ok := strings.Cut(spec, "=")
+		if !ok {
+			return opts, fmt.Errorf("bad --csv-rename entry '%s', should be of form 'col=newname'", spec)
+		}
+		if opts.Rename == nil {
+			opts.Rename = make(map[string]string)
+		}
+		opts.Rename[col] = newName
+	}
+	return opts, nil
+}
+
 func autoInputFormat(r io.Reader) (infmt string, r2 io.Reader, err error) {
 	df, r2, err := datafmt.AutodetectReader(r)
 	if err != nil {
@@ -188,6 +523,37 @@ func autoInputFormat(r io.Reader) (infmt string, r2 io.Reader, err error) {
 	}
 }
 
+func newRunOptions(hctx *hsruntime.Context) (opts hscommand.RunOptions, err error) {
+	if opts.Retry, err = newRetryFunc(hctx); err != nil {
+		return opts, err
+	}
+	opts.SaveBody = runFlagVals.saveBody
+	if opts.AcceptEncoding, err = hscommand.ParseAcceptEncoding(runFlagVals.acceptEncoding); err != nil {
+		return opts, fmt.Errorf("bad --accept-encoding: %w", err)
+	}
+	opts.CompressBody = runFlagVals.compressBody
+	opts.Auth = runFlagVals.auth
+	return opts, nil
+}
+
+// newBuildOptions returns options for building requests per flags, given the ids bound to each input record's source.
+func newBuildOptions(inputIds []scope.Ident) (opts hscommand.BuildOptions) {
+	opts.IdempotencyKey = buildFlagVals.idemKey
+	opts.InputSource = inputIds
+	opts.Form = buildFlagVals.form
+	switch buildFlagVals.bodyfmt {
+	case "json":
+		opts.BodyFormat = datafmt.JSON
+	case "form":
+		opts.BodyFormat = datafmt.FormData
+	case "msgpack":
+		opts.BodyFormat = datafmt.MsgPack
+	case "cbor":
+		opts.BodyFormat = datafmt.CBOR
+	}
+	return opts
+}
+
 func isFileOutput(w io.Writer) bool {
 	if f, ok := w.(*os.File); !ok {
 		return false
@@ -213,6 +579,8 @@ func newOutputFormatter(outfmt string) outputFormatter {
 			respObj := rr.(record.Object)["response"].(record.Object)
 			if errVal, ok := respObj["error"]; ok {
 				return errVal, nil
+			} else if path, ok := respObj["body_file"]; ok { // body saved by --save-body
+				return path, nil
 			}
 			return respObj["body"], nil
 		}
@@ -222,6 +590,8 @@ func newOutputFormatter(outfmt string) outputFormatter {
 			var statusStr, bodyStr string
 			if errVal, ok := respObj["error"]; ok {
 				statusStr, bodyStr = "000", record.CoerceString(errVal)
+			} else if path, ok := respObj["body_file"]; ok { // body saved by --save-body
+				statusStr, bodyStr = record.CoerceString(respObj["status"]), record.CoerceString(path)
 			} else {
 				statusStr, bodyStr = record.CoerceString(respObj["status"]), record.CoerceString(respObj["body"])
 			}
@@ -231,28 +601,37 @@ func newOutputFormatter(outfmt string) outputFormatter {
 	panic(fmt.Errorf("unsupported outfmt '%s'", outfmt))
 }
 
-func openOutput(out, err io.StringWriter, outfmt string) *responseSplitFileSink {
+func openOutput(out, err io.Writer, outfmt, emit string, cols []column, binds *scope.Bindings, inputIds []scope.Ident, sourceFields bool) *responseSplitFileSink {
 	warnIfErr := false
 	if outfmt == "auto" {
 		outfmt, warnIfErr = "body", true
 	}
-	return &responseSplitFileSink{
+
+	sink := &responseSplitFileSink{
 		OutFmt: newOutputFormatter(outfmt),
 		Out:    out,
 		Err:    err,
 
+		newEnc:    func(w io.Writer) recordEncoder { return newRecordEncoder(w, emit, columnNames(cols)) },
 		warnIfErr: warnIfErr,
 	}
+	if len(cols) > 0 {
+		sink.OutFmt, sink.warnIfErr = newColumnsFormatter(cols, binds, inputIds, sourceFields), false
+	}
+	return sink
 }
 
 type responseSplitFileSink struct {
 	mtx    sync.Mutex
 	OutFmt outputFormatter
-	Out    io.StringWriter
-	Err    io.StringWriter
+	Out    io.Writer
+	Err    io.Writer
 
 	HadErr    bool // set if any row went to Err
+	newEnc    func(w io.Writer) recordEncoder
+	encs      map[io.Writer]recordEncoder // lazily created per writer
 	warnIfErr bool
+	skipped   int // count of input records skipped due to errors
 }
 
 func (w *responseSplitFileSink) Sink(rec record.Record) (err error) {
@@ -266,18 +645,55 @@ func (w *responseSplitFileSink) Sink(rec record.Record) (err error) {
 		return err
 	}
 
-	recStr := record.CoerceString(rec)
-
 	w.mtx.Lock()
 	defer w.mtx.Unlock()
-	_, err = writeTo.WriteString(recStr)
-	_, err = writeTo.WriteString("\n")
+	err = w.encoder(writeTo).Encode(rec)
 	w.HadErr = w.HadErr || hadErr
 	return err
 }
 
-func (w *responseSplitFileSink) Finish() {
+// SinkError writes an error record (not a response) to Err, as-is.
+func (w *responseSplitFileSink) SinkError(rec record.Record) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.encoder(w.Err).Encode(rec)
+}
+
+// SkipError records that an input record was skipped due to an error, to be warned of by Finish.
+func (w *responseSplitFileSink) SkipError() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.skipped++
+}
+
+// encoder returns the encoder for writeTo, creating it if needed. w.mtx must be held.
+func (w *responseSplitFileSink) encoder(writeTo io.Writer) recordEncoder {
+	enc := w.encs[writeTo]
+	if enc == nil {
+		if w.encs == nil {
+			w.encs = make(map[io.Writer]recordEncoder)
+		}
+		enc = w.newEnc(writeTo)
+		w.encs[writeTo] = enc
+	}
+	return enc
+}
+
+// Finish flushes all output, and prints any warnings.
+func (w *responseSplitFileSink) Finish() (err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, enc := range w.encs {
+		if flushErr := enc.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+
+	if w.skipped > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: skipped %d input records due to errors\n", w.skipped)
+	}
 	if w.warnIfErr && w.HadErr {
 		_, _ = fmt.Fprintf(os.Stderr, "warning: got some non-200 status codes, but only response bodies were printed in default output mode; if status codes are needed, run with -o with more verbose output format\n")
 	}
+	return err
 }
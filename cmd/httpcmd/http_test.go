@@ -1,10 +1,20 @@
 package httpcmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"io"
 	"math/rand"
 	"net/http"
+	"reflect"
 	"strings"
+	"testing"
+
+	"github.com/daboyuka/hs/program/command"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
 )
 
 type flakyTransport struct {
@@ -22,3 +32,78 @@ func (f flakyTransport) RoundTrip(request *http.Request) (*http.Response, error)
 	}
 	return f.Next.RoundTrip(request)
 }
+
+// buildFailCommand is as respondCommand, but fails to build on input "build".
+type buildFailCommand struct{}
+
+func (buildFailCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (record.Stream, *scope.Bindings, error) {
+	if src, ok := in.(sourcedRecord); ok && src.rec == "build" {
+		return nil, nil, errors.New("bad URL")
+	}
+	return respondCommand{}.Run(ctx, in, binds)
+}
+
+func TestErrorHandlerEmit(t *testing.T) {
+	defer func(prev string) { runFlagVals.onError = prev }(runFlagVals.onError)
+	runFlagVals.onError = "emit"
+
+	var out, fails strings.Builder
+	sink := openOutput(&out, &fails, "full", "jsonl", nil, nil, nil, false)
+	onError := newErrorHandler(sink)
+
+	input := &record.SliceStream{Records: sourced("a", "build", "b")}
+	if err := command.RunParallel(context.Background(), buildFailCommand{}, nil, input, sink, 1, onError, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := onError(command.StageInput, nil, errors.New("line 4: bad JSON")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := sink.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Count(out.String(), "\n"); got != 2 {
+		t.Errorf("expected 2 output records, got\n%s", out.String())
+	}
+	expect := `{"error":"bad URL","input":"build","input_file":"-","input_line":2,"stage":"build"}` + "\n" +
+		`{"error":"line 4: bad JSON","input":null,"stage":"input"}` + "\n"
+	if fails.String() != expect {
+		t.Errorf("fails output: got\n%s\nexpect\n%s", fails.String(), expect)
+	}
+}
+
+func TestOpenInputCompression(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte("a\nb\n"))
+	_ = gw.Close()
+
+	// MessagePack beginning with the gzip ID bytes (0x1f 0x8b): 31, then a map of 11 entries ("a":0 to "k":10)
+	mp := []byte{0x1f, 0x8b}
+	mpMap := record.Object{}
+	for i := 0; i < 11; i++ {
+		mp = append(mp, 0xa1, byte('a'+i), byte(i))
+		mpMap[string(rune('a'+i))] = float64(i)
+	}
+
+	tests := []struct {
+		Name   string
+		Infmt  string
+		Input  []byte
+		Expect record.Array
+	}{
+		{Name: "gzip lines", Infmt: "lines", Input: gz.Bytes(), Expect: record.Array{"a", "b"}},
+		{Name: "gzip raw", Infmt: "raw", Input: gz.Bytes(), Expect: record.Array{gz.String()}}, // raw is as-is
+		{Name: "msgpack with gzip ID", Infmt: "msgpack", Input: mp, Expect: record.Array{31.0, mpMap}},
+	}
+	for _, tst := range tests {
+		in, err := openInput(bytes.NewReader(tst.Input), tst.Infmt)
+		if err != nil {
+			t.Errorf("test '%s': unexpected error: %s", tst.Name, err)
+			continue
+		}
+		if got, err := record.CollectStream(in); err != nil {
+			t.Errorf("test '%s': unexpected error: %s", tst.Name, err)
+		} else if !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': got %v, expect %v", tst.Name, got, tst.Expect)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package httpcmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/daboyuka/hs/program/command"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// inputVarNames are the variables bound to the source of each input record: the input filename ("-" for stdin), and
+// the line number in that file where the record begins (null if not applicable to the input format).
+var inputVarNames = []string{"input_file", "input_line"}
+
+// sourcedRecord is an input record annotated with its source, as produced by fileInputStream.
+type sourcedRecord struct {
+	rec  record.Record
+	file string
+	line record.Record // float64, or nil if unknown
+
+	entry string // journal entry, if journaling (see journal)
+}
+
+// fileInputStream concatenates the record streams of a sequence of input files, each opened (and its format
+// autodetected, if applicable) only once the previous is exhausted. It produces sourcedRecords.
+type fileInputStream struct {
+	infmt string
+	files []string // remaining files to open
+
+	mtx  sync.Mutex
+	file string
+	cur  record.Stream
+	cls  io.Closer
+}
+
+// openInputs expands patterns (filenames or globs, or "-" for stdin) and returns a stream of the records of all
+// matching files, in order. It is an error for a glob to match no files.
+func openInputs(patterns []string, infmt string) (record.Stream, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"-"}
+	}
+
+	var files []string
+	for _, pat := range patterns {
+		if pat == "-" {
+			files = append(files, pat)
+			continue
+		}
+		matches, err := filepath.Glob(pat)
+		if err != nil {
+			return nil, fmt.Errorf("bad input pattern '%s': %w", pat, err)
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("no input files match '%s'", pat)
+		}
+		files = append(files, matches...)
+	}
+	return &fileInputStream{infmt: infmt, files: files}, nil
+}
+
+func (s *fileInputStream) Next() (record.Record, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for {
+		if s.cur == nil {
+			if len(s.files) == 0 {
+				return nil, io.EOF
+			} else if err := s.openNext(); err != nil {
+				return nil, err
+			}
+		}
+
+		rec, line, err := nextWithPosition(s.cur)
+		if err == io.EOF {
+			if err := s.closeCur(); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.file, err)
+		}
+		return sourcedRecord{rec: rec, file: s.file, line: line}, nil
+	}
+}
+
+func (s *fileInputStream) openNext() (err error) {
+	s.file, s.files = s.files[0], s.files[1:]
+
+	var r io.Reader = os.Stdin
+	if s.file != "-" {
+		f, err := os.Open(s.file)
+		if err != nil {
+			return record.RecordError{Err: err} // skippable; remaining files may still be read
+		}
+		r, s.cls = f, f
+	}
+
+	if s.cur, err = openInput(r, s.infmt); err != nil {
+		_ = s.closeCur()
+		return fmt.Errorf("%s: %w", s.file, err)
+	}
+	return nil
+}
+
+func (s *fileInputStream) closeCur() (err error) {
+	if s.cls != nil {
+		err = s.cls.Close()
+	}
+	s.cur, s.cls = nil, nil
+	return err
+}
+
+func nextWithPosition(stream record.Stream) (rec record.Record, line record.Record, err error) {
+	if ps, ok := stream.(record.PositionStream); ok {
+		rec, ln, err := ps.NextPosition()
+		return rec, float64(ln), err
+	}
+	rec, err = stream.Next()
+	return rec, nil, err
+}
+
+// bindInputSourceCommand unwraps sourcedRecords, binding Ids (as created for inputVarNames) to their source when running
+// Cmd. If AddFields is set, the source is also added to each output record (an object), as fields named per
+// inputVarNames (see bindInputSourceFields).
+type bindInputSourceCommand struct {
+	Cmd       command.Command
+	Ids       []scope.Ident
+	AddFields bool
+}
+
+func (cmd bindInputSourceCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (out record.Stream, outBinds *scope.Bindings, err error) {
+	src, ok := in.(sourcedRecord)
+	if !ok {
+		return cmd.Cmd.Run(ctx, in, binds)
+	}
+	binds = scope.NewBindings(binds, map[scope.Ident]record.Record{cmd.Ids[0]: src.file, cmd.Ids[1]: src.line})
+	out, outBinds, err = cmd.Cmd.Run(ctx, src.rec, binds)
+	if err == nil && cmd.AddFields {
+		out = addFieldStream{stream: out, field: inputVarNames[0], val: src.file}
+		out = addFieldStream{stream: out, field: inputVarNames[1], val: src.line}
+	}
+	return out, outBinds, err
+}
+
+// bindInputSourceFields returns binds with ids (as created for inputVarNames) bound to the input source fields of output
+// record rec, as added by bindInputSourceCommand (null if absent).
+func bindInputSourceFields(rec record.Record, ids []scope.Ident, binds *scope.Bindings) *scope.Bindings {
+	obj, _ := rec.(record.Object)
+	vals := make(map[scope.Ident]record.Record, len(ids))
+	for i, id := range ids {
+		vals[id] = obj[inputVarNames[i]]
+	}
+	return scope.NewBindings(binds, vals)
+}
+
+// withoutInputSourceFields returns output record rec without the input source fields added by bindInputSourceCommand.
+func withoutInputSourceFields(rec record.Record) record.Record {
+	obj, ok := rec.(record.Object)
+	if !ok {
+		return rec
+	}
+	out := make(record.Object, len(obj))
+	for k, v := range obj {
+		if k != inputVarNames[0] && k != inputVarNames[1] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// addSourceFields returns whether to add input source fields to output records (see bindInputSourceCommand): if
+// requested by --source-fields, or to bind the input source variables for --col.
+func addSourceFields() bool {
+	return len(runFlagVals.cols) > 0 || (runFlagVals.srcField && runFlagVals.outfmt == "full")
+}
+
+// spillOptions returns options for spilling input records (sourcedRecords) to disk, per flags; or nil if disabled.
+func spillOptions() *record.SpillOptions {
+	if !commonFlagVals.inputSpill {
+		return nil
+	}
+	return &record.SpillOptions{
+		Marshal: func(rec record.Record) ([]byte, error) {
+			src := rec.(sourcedRecord)
+			return json.Marshal(record.Array{src.file, src.line, src.rec, src.entry})
+		},
+		Unmarshal: func(data []byte) (record.Record, error) {
+			var src [4]record.Record
+			if err := json.Unmarshal(data, &src); err != nil {
+				return nil, err
+			}
+			file, _ := src[0].(string)
+			entry, _ := src[3].(string)
+			return sourcedRecord{file: file, line: src[1], rec: src[2], entry: entry}, nil
+		},
+	}
+}
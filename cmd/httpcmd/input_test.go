@@ -0,0 +1,92 @@
+package httpcmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/daboyuka/hs/program/record"
+)
+
+func TestOpenInputs(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte("[1]\n[2]\n"))
+	_ = gw.Close()
+	files := map[string][]byte{
+		"a.json":    []byte("{\"a\":1}\n\n{\"a\":2}\n"),
+		"b.txt":     []byte("x\ny\n"),
+		"c.json.gz": gz.Bytes(),
+		"stdin":     []byte("\"s\"\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	a, b, c := path("a.json"), path("b.txt"), path("c.json.gz")
+
+	stdin, err := os.Open(path("stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	defer func(prev *os.File) { os.Stdin = prev }(os.Stdin)
+	os.Stdin = stdin
+
+	aRecs := []record.Record{
+		sourcedRecord{rec: record.Object{"a": 1.0}, file: a, line: 1.0},
+		sourcedRecord{rec: record.Object{"a": 2.0}, file: a, line: 3.0},
+	}
+	bRecs := []record.Record{sourcedRecord{rec: "x", file: b, line: 1.0}, sourcedRecord{rec: "y", file: b, line: 2.0}}
+	cRecs := []record.Record{
+		sourcedRecord{rec: record.Array{1.0}, file: c, line: 1.0},
+		sourcedRecord{rec: record.Array{2.0}, file: c, line: 2.0},
+	}
+	stdinRecs := []record.Record{sourcedRecord{rec: "s", file: "-", line: 1.0}}
+	concat := func(recss ...[]record.Record) (out record.Array) {
+		for _, recs := range recss {
+			out = append(out, recs...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		Name     string
+		Patterns []string
+		Expect   record.Array
+		WantErr  bool
+	}{
+		{Name: "default stdin", Patterns: nil, Expect: concat(stdinRecs)},
+		{Name: "repeated, each autodetected", Patterns: []string{b, a, c}, Expect: concat(bRecs, aRecs, cRecs)},
+		{Name: "glob, in sorted order", Patterns: []string{path("*.json*")}, Expect: concat(aRecs, cRecs)},
+		{Name: "glob and stdin", Patterns: []string{path("b.*"), "-", a}, Expect: concat(bRecs, stdinRecs, aRecs)},
+		{Name: "glob matching nothing", Patterns: []string{a, path("*.yaml")}, WantErr: true},
+		{Name: "bad glob", Patterns: []string{path("[")}, WantErr: true},
+	}
+	for _, tst := range tests {
+		if _, err := stdin.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		in, err := openInputs(tst.Patterns, "auto")
+		if tst.WantErr {
+			if err == nil {
+				t.Errorf("test '%s': expected error", tst.Name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("test '%s': unexpected error: %s", tst.Name, err)
+			continue
+		}
+		if got, err := record.CollectStream(in); err != nil {
+			t.Errorf("test '%s': unexpected error: %s", tst.Name, err)
+		} else if !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': got %v, expect %v", tst.Name, got, tst.Expect)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package httpcmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daboyuka/hs/program/command"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+const journalCommitInterval = time.Second
+
+// journal records which input records have completed in a file, so that a rerun with the same journal may skip them.
+// Each line is an entry "<index> <key>": the input record's index, and a key identifying it by content, as a hash of the
+// record and its occurrence number among identical records.
+//
+// An entry is added once its outputs are written, and entries are committed (synced) periodically, so a killed run loses
+// at most the last interval's entries; those records are rerun (and their outputs written again).
+type journal struct {
+	f    *os.File
+	done map[string]bool // keys completed by prior runs
+
+	inMtx  sync.Mutex
+	index  int
+	counts map[[sha256.Size]byte]int // occurrences of each record so far, by hash
+
+	mtx     sync.Mutex
+	pending []string // completed entries not yet committed
+	err     error    // first commit error
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// openJournal opens (creating if needed) the journal file at path, and begins committing entries.
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	j := &journal{f: f, done: make(map[string]bool), counts: make(map[[sha256.Size]byte]int), stop: make(chan struct{})}
+
+	lines := bytes.Split(data, []byte{'\n'})
+	for _, line := range lines[:len(lines)-1] { // last is empty, or partially written by a killed run
+		if _, key, ok := strings.Cut(string(line), " "); ok {
+			j.done[key] = true
+		}
+	}
+	if partial := lines[len(lines)-1]; len(partial) > 0 {
+		if _, err := f.Write([]byte{'\n'}); err != nil { // terminate partial entry, so it doesn't corrupt the next
+			_ = f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	j.wg.Add(1)
+	go j.run()
+	return j, nil
+}
+
+func (j *journal) run() {
+	defer j.wg.Done()
+	t := time.NewTicker(journalCommitInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			j.commit()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Close commits any remaining entries and closes the journal file.
+func (j *journal) Close() error {
+	close(j.stop)
+	j.wg.Wait()
+	j.commit()
+	if err := j.f.Close(); err != nil && j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+// Skip returns a stream of the sourcedRecords of in, assigning each its journal entry, and omitting those completed by
+// prior runs.
+func (j *journal) Skip(in record.Stream) record.Stream {
+	return journalSkipStream{in: in, j: j}
+}
+
+type journalSkipStream struct {
+	in record.Stream
+	j  *journal
+}
+
+func (s journalSkipStream) Next() (record.Record, error) {
+	for {
+		rec, err := s.in.Next()
+		if err != nil {
+			return nil, err
+		}
+		src := rec.(sourcedRecord)
+
+		index, key, err := s.j.key(src.rec)
+		if err != nil {
+			return nil, record.RecordError{Err: fmt.Errorf("journal: %w", err)}
+		} else if s.j.done[key] {
+			continue
+		}
+		src.entry = strconv.Itoa(index) + " " + key
+		return src, nil
+	}
+}
+
+// key returns the index and key of the next input record rec.
+func (j *journal) key(rec record.Record) (index int, key string, err error) {
+	data, err := json.Marshal(rec) // canonical: object keys are sorted
+	if err != nil {
+		return 0, "", err
+	}
+	sum := sha256.Sum256(data)
+
+	j.inMtx.Lock()
+	defer j.inMtx.Unlock()
+	index = j.index
+	j.index++
+	j.counts[sum]++
+	return index, hex.EncodeToString(sum[:16]) + "/" + strconv.Itoa(j.counts[sum]), nil
+}
+
+// complete records entry as completed, to be committed.
+func (j *journal) complete(entry string) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	j.pending = append(j.pending, entry)
+}
+
+// commit writes and syncs all pending entries.
+func (j *journal) commit() {
+	j.mtx.Lock()
+	pending := j.pending
+	j.pending = nil
+	j.mtx.Unlock()
+
+	if len(pending) == 0 || j.err != nil {
+		return
+	}
+	if _, err := j.f.WriteString(strings.Join(pending, "\n") + "\n"); err != nil {
+		j.err = err
+	} else if err := j.f.Sync(); err != nil {
+		j.err = err
+	}
+}
+
+// journalCommand runs Cmd on sourcedRecords, recording each as completed in J once all its outputs are read, unless
+// any is a response not received (connection error, or not sent due to interrupt), or an error occurs.
+type journalCommand struct {
+	Cmd command.Command
+	J   *journal
+}
+
+func (cmd journalCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (out record.Stream, outBinds *scope.Bindings, err error) {
+	out, outBinds, err = cmd.Cmd.Run(ctx, in, binds)
+	if src, ok := in.(sourcedRecord); ok && err == nil && src.entry != "" {
+		out = &journalStream{Stream: out, j: cmd.J, entry: src.entry}
+	}
+	return
+}
+
+type journalStream struct {
+	record.Stream
+	j     *journal
+	entry string
+
+	mtx      sync.Mutex
+	unsent   bool // if set, an output was a response not received
+	finished bool
+}
+
+func (s *journalStream) Next() (record.Record, error) {
+	rec, err := s.Stream.Next()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err == nil {
+		s.unsent = s.unsent || isUnreceivedResponse(rec)
+	} else if err == io.EOF && !s.unsent && !s.finished {
+		s.j.complete(s.entry)
+		s.finished = true
+	}
+	return rec, err
+}
+
+// isUnreceivedResponse returns whether rec is a response record with no response received (i.e., a connection error).
+func isUnreceivedResponse(rec record.Record) bool {
+	recObj, _ := rec.(record.Object)
+	respObj, _ := recObj["response"].(record.Object)
+	return respObj["error"] != nil
+}
+
+// attachJournal opens the journal per the --journal flag, if set, returning input with completed records skipped and
+// hcmd recording completions. closeJournal must be called once hcmd is done.
+func attachJournal(input record.Stream, hcmd command.Command) (newInput record.Stream, newCmd command.Command, closeJournal func() error, err error) {
+	if runFlagVals.journal == "" {
+		return input, hcmd, func() error { return nil }, nil
+	} else if runFlagVals.emit == "table" {
+		return nil, nil, nil, errors.New("--journal is incompatible with --emit table (output is written only at the end)")
+	}
+
+	j, err := openJournal(runFlagVals.journal)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return j.Skip(input), journalCommand{Cmd: hcmd, J: j}, func() error {
+		if err := j.Close(); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+		return nil
+	}, nil
+}
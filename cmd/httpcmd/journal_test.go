@@ -0,0 +1,177 @@
+package httpcmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// respondCommand outputs a response record for each input: a connection error if the input is "fail", else a 200.
+type respondCommand struct{}
+
+func (respondCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (record.Stream, *scope.Bindings, error) {
+	resp := record.Object{"status": 200.0}
+	if in == "fail" {
+		resp = record.Object{"error": "connection refused"}
+	}
+	return &record.SingletonStream{Rec: record.Object{"input": in, "response": resp}}, binds, nil
+}
+
+// sourced returns recs as sourcedRecords.
+func sourced(recs ...record.Record) []record.Record {
+	out := make([]record.Record, len(recs))
+	for i, rec := range recs {
+		out[i] = sourcedRecord{rec: rec, file: "-", line: float64(i + 1)}
+	}
+	return out
+}
+
+// runJournaled runs respondCommand on input, journaled in j, returning the input records run.
+func runJournaled(t *testing.T, j *journal, input record.Stream) (ran record.Array) {
+	t.Helper()
+	cmd := journalCommand{Cmd: bindInputSourceCommand{Cmd: respondCommand{}, Ids: make([]scope.Ident, 2)}, J: j}
+	for {
+		in, err := input.Next()
+		if err == io.EOF {
+			return ran
+		} else if err != nil {
+			t.Fatalf("unexpected input error: %s", err)
+		}
+		ran = append(ran, in.(sourcedRecord).rec)
+
+		out, _, err := cmd.Run(context.Background(), in, nil)
+		if err != nil {
+			t.Fatalf("unexpected run error: %s", err)
+		}
+		for _, err = out.Next(); err == nil; _, err = out.Next() {
+		}
+		if err != io.EOF {
+			t.Fatalf("unexpected output error: %s", err)
+		}
+	}
+}
+
+func TestJournalSkip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	input := func() []record.Record { return sourced("a", "fail", "a", record.Object{"k": "v"}) }
+
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop after the first 3, as if interrupted; "fail" is not completed
+	in := &record.SliceStream{Records: input()[:3]}
+	if ran := runJournaled(t, j, j.Skip(in)); len(ran) != 3 {
+		t.Fatalf("first run: expected 3 records run, got %v", ran)
+	} else if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	ran := runJournaled(t, j, j.Skip(&record.SliceStream{Records: input()}))
+	if expect := (record.Array{"fail", record.Object{"k": "v"}}); !reflect.DeepEqual(ran, expect) {
+		t.Errorf("second run: expected %v run, got %v", expect, ran)
+	}
+}
+
+func TestJournalPartialEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runJournaled(t, j, j.Skip(&record.SliceStream{Records: sourced("a", "b")}))
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the last entry, as if killed while writing it
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(path, data[:len(data)-5], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran := runJournaled(t, j, j.Skip(&record.SliceStream{Records: sourced("a", "b", "c")})); !reflect.DeepEqual(ran, record.Array{"b", "c"}) {
+		t.Errorf("second run: expected [b c] run, got %v", ran)
+	} else if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The partial entry is terminated, so later entries are intact
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if ran := runJournaled(t, j, j.Skip(&record.SliceStream{Records: sourced("a", "b", "c")})); len(ran) != 0 {
+		t.Errorf("third run: expected none run, got %v", ran)
+	}
+	data, _ = os.ReadFile(path)
+	if lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); len(lines) != 4 { // a, partial b, b, c
+		t.Errorf("expected 4 journal lines, got %q", lines)
+	}
+}
+
+func TestJournalSpill(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	defer func(prev bool) { commonFlagVals.inputSpill = prev }(commonFlagVals.inputSpill)
+	commonFlagVals.inputSpill = true
+
+	// Journaled records pass through a spill queue, as with --input-spill
+	spilled := func(j *journal, recs []record.Record) record.Stream {
+		opts := spillOptions()
+		opts.Dir = t.TempDir()
+		q := record.NewSpillQueue(1, *opts)
+		skip := j.Skip(&record.SliceStream{Records: recs})
+		for {
+			rec, err := skip.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			} else if err := q.Push(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		q.Close(nil)
+		t.Cleanup(func() { _ = q.Release() })
+		return q
+	}
+
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran := runJournaled(t, j, spilled(j, sourced("a", "b", "c"))); len(ran) != 3 {
+		t.Fatalf("first run: expected 3 records run, got %v", ran)
+	} else if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if ran := runJournaled(t, j, spilled(j, sourced("a", "b", "c", "d"))); !reflect.DeepEqual(ran, record.Array{"d"}) {
+		t.Errorf("second run: expected [d] run, got %v", ran)
+	}
+}
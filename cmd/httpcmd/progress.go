@@ -2,6 +2,7 @@ package httpcmd
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sync/atomic"
 	"time"
@@ -15,23 +16,43 @@ import (
 // progress logger exits (shortly after ctx is cancelled).
 //
 // Progress is determined by outCounter (caller should increment it as records are processed); progress bar max is
-// indefinite until stream 'in' hits EOF, when it becomes total records streamed.
+// indefinite until stream 'in' hits EOF, when it becomes total records streamed. To find this sooner, up to maxBuffer
+// records are read ahead; if spill is non-nil, records beyond that are read ahead into a temporary file.
 //
 // Rendering only occurs if  to render is based on mode ("true", "false", "auto") and isOutTTY (if auto, render only if !isOutTTY)
-func attachProgressLogger(ctx context.Context, in record.Stream, enable bool, maxBuffer int, interval time.Duration, w io.Writer) (newIn record.Stream, outCounter *atomic.Uint64, waitForShutdown func()) {
+func attachProgressLogger(ctx context.Context, in record.Stream, enable bool, maxBuffer int, spill *record.SpillOptions, interval time.Duration, w io.Writer) (newIn record.Stream, outCounter *atomic.Uint64, waitForShutdown func()) {
 	if !enable {
 		return in, nil, func() {}
 	}
 
 	outCounter = &atomic.Uint64{}
 	counter := &record.CountingStream{Stream: in}
-	outBuf := record.ChannelStream{Ch: make(chan record.RecordAndError, maxBuffer)}
+	release := func() {}
+	if spill != nil {
+		newIn, release = readAheadSpill(ctx, counter, maxBuffer, *spill)
+	} else {
+		newIn = readAhead(ctx, counter, maxBuffer)
+	}
+
+	doneCh := make(chan struct{})
+	go runProgressLogger(ctx, doneCh, counter, outCounter, interval, w)
+
+	return newIn, outCounter, func() {
+		<-doneCh
+		release()
+	}
+}
+
+// readAhead reads records from in into a buffer of up to maxBuffer records, until ctx is cancelled (then ending the
+// returned stream).
+func readAhead(ctx context.Context, in record.Stream, maxBuffer int) record.Stream {
+	outBuf := record.ChannelStream{Ch: make(chan record.RecordAndError, max(maxBuffer, 0))}
 	go func() {
+		defer close(outBuf.Ch) // on EOF, or once ctx is cancelled, so readers needn't block
 		doneCh := ctx.Done()
 		for {
-			r, err := counter.Next()
+			r, err := in.Next()
 			if err == io.EOF {
-				close(outBuf.Ch)
 				return
 			}
 
@@ -42,11 +63,39 @@ func attachProgressLogger(ctx context.Context, in record.Stream, enable bool, ma
 			}
 		}
 	}()
+	return outBuf
+}
 
-	doneCh := make(chan struct{})
-	go runProgressLogger(ctx, doneCh, counter, outCounter, interval, w)
-
-	return outBuf, outCounter, func() { <-doneCh }
+// readAheadSpill reads all records from in into a SpillQueue holding up to maxBuffer records in memory, until ctx is
+// cancelled. release deletes any spill file, and must be called after ctx is cancelled.
+func readAheadSpill(ctx context.Context, in record.Stream, maxBuffer int, spill record.SpillOptions) (out record.Stream, release func()) {
+	q := record.NewSpillQueue(maxBuffer, spill)
+	stop := context.AfterFunc(ctx, func() { q.Close(ctx.Err()) })
+	go func() {
+		for {
+			r, err := in.Next()
+			var recErr record.RecordError
+			if errors.As(err, &recErr) {
+				err = q.PushError(record.RecordError{Err: err}) // wrap err itself, to retain its full message
+			} else if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				q.Close(err)
+				return
+			} else {
+				err = q.Push(r)
+			}
+			if err != nil {
+				q.Close(err)
+				return
+			}
+		}
+	}()
+	return q, func() {
+		stop()
+		_ = q.Release()
+	}
 }
 
 func runProgressLogger(ctx context.Context, doneCh chan<- struct{}, in *record.CountingStream, out *atomic.Uint64, interval time.Duration, w io.Writer) {
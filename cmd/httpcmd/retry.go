@@ -0,0 +1,102 @@
+package httpcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/daboyuka/hs/hsruntime"
+	hscommand "github.com/daboyuka/hs/hsruntime/command"
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/record"
+)
+
+// retryConfigFlags maps keys of the RETRY config object to the flags they set defaults for.
+var retryConfigFlags = map[string]string{
+	"max":            "retry",
+	"delay":          "retry-delay",
+	"backoff":        "retry-backoff",
+	"max_delay":      "retry-max-delay",
+	"jitter":         "retry-jitter",
+	"status":         "retry-status",
+	"retry_after":    "retry-after",
+	"non_idempotent": "retry-non-idempotent",
+	"if":             "retry-if",
+}
+
+// newRetryFunc returns the RetryFunc per the retry flags, with defaults for those not given set by config RETRY; or nil
+// if retries are disabled.
+func newRetryFunc(hctx *hsruntime.Context) (hscommand.RetryFunc, error) {
+	if err := applyRetryConfig(hctx); err != nil {
+		return nil, err
+	}
+
+	policy := hscommand.RetryPolicy{
+		Max:           runFlagVals.retries,
+		Delay:         runFlagVals.retryDelay,
+		Backoff:       runFlagVals.retryBackoff,
+		MaxDelay:      runFlagVals.retryMaxDelay,
+		Jitter:        runFlagVals.retryJitter,
+		RetryAfter:    runFlagVals.retryAfter,
+		NonIdempotent: runFlagVals.retryUnsafe,
+		Binds:         hctx.Globals.Binds,
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return nil, fmt.Errorf("bad retry jitter %v: must be between 0 and 1", policy.Jitter)
+	}
+
+	var err error
+	if policy.Statuses, err = hscommand.ParseStatusSet(runFlagVals.retryStatus); err != nil {
+		return nil, fmt.Errorf("bad retry statuses: %w", err)
+	}
+	if src := runFlagVals.retryIf; src != "" {
+		if policy.If, err = parser.ParseExpr(src, hctx.Globals.Scope, hctx.Funcs); err != nil {
+			return nil, fmt.Errorf("bad retry condition '%s': %w", src, err)
+		}
+	}
+	return policy.RetryFunc(), nil
+}
+
+// applyRetryConfig sets retry flags not given on the command line from config RETRY, if bound.
+func applyRetryConfig(hctx *hsruntime.Context) error {
+	cfgIntf, _ := hctx.Globals.Lookup("RETRY")
+
+	cfg, ok := cfgIntf.(map[string]interface{})
+	if cfgIntf == nil {
+		return nil
+	} else if !ok {
+		return fmt.Errorf("expected map for RETRY, got %T", cfgIntf)
+	}
+
+	for key, v := range cfg {
+		flagName, ok := retryConfigFlags[key]
+		if !ok {
+			return fmt.Errorf("unknown RETRY setting '%s'", key)
+		} else if runFlags.Changed(flagName) {
+			continue
+		}
+
+		v, err := record.Normalize(v) // config may hold non-JSON types (e.g. int, from YAML)
+		if err != nil {
+			return fmt.Errorf("bad RETRY setting '%s': %w", key, err)
+		}
+
+		var val string
+		switch v := v.(type) {
+		case string:
+			val = v
+		case bool:
+			val = strconv.FormatBool(v)
+		case float64:
+			val = strconv.FormatFloat(v, 'f', -1, 64)
+			if flagName == "retry-delay" || flagName == "retry-max-delay" {
+				val += "s" // durations given as numbers are seconds
+			}
+		default:
+			return fmt.Errorf("bad RETRY setting '%s': unexpected value %s", key, record.CoerceString(v))
+		}
+		if err := runFlags.Set(flagName, val); err != nil {
+			return fmt.Errorf("bad RETRY setting '%s': %w", key, err)
+		}
+	}
+	return nil
+}
@@ -8,39 +8,59 @@ import (
 	"github.com/spf13/cobra"
 
 	cmdctx "github.com/daboyuka/hs/cmd/context"
-	"github.com/daboyuka/hs/hsruntime"
 	hscommand "github.com/daboyuka/hs/hsruntime/command"
 	"github.com/daboyuka/hs/program/command"
+	"github.com/daboyuka/hs/program/scope"
 )
 
-const maxInputBufferRecords = 1 << 16
+const (
+	maxInputBufferRecords  = 1 << 16
+	orderedWindowPerWorker = 16 // with --ordered, max. inputs in progress or buffered per parallel request
+)
 
 func cmdRun(cmd *cobra.Command, args []string) (finalErr error) {
-	hctx, err := cmdctx.Init(hsruntime.Options{CookieSpecs: runFlagVals.cookies}, true)
+	opts, err := newRuntimeOptions()
+	if err != nil {
+		return err
+	}
+	hctx, err := cmdctx.Init(opts, true)
 	if err != nil {
 		return err
 	}
 	scp, binds := hctx.Globals.Scope, hctx.Globals.Binds
+	scp, inputIds := scope.NewScope(scp, inputVarNames...)
 
-	var retry hscommand.RetryFunc
-	if runFlagVals.retries > 0 {
-		retry = func(req hscommand.RequestAndBody, resp hscommand.ResponseAndBody, attempt int) (backoff time.Duration, retry bool) {
-			return time.Second, (resp.HTTPError != nil || resp.StatusCode/100 == 5) && attempt < runFlagVals.retries
-		}
+	runOpts, err := newRunOptions(hctx)
+	if err != nil {
+		return err
 	}
 
-	hcmd, scp, err := hscommand.NewHttpRunCommand(scp, hctx, retry)
+	hcmdRaw, _, err := hscommand.NewHttpRunCommand(runOpts, scp, hctx)
 	if err != nil {
 		return err
 	}
+	var hcmd command.Command = bindInputSourceCommand{Cmd: hcmdRaw, Ids: inputIds, AddFields: addSourceFields()}
 
-	input, err := openInput(os.Stdin, commonFlagVals.infmt)
+	input, err := openInputs(commonFlagVals.inputs, commonFlagVals.infmt)
 	if err != nil {
 		return err
 	}
+	input, hcmd, closeJournal, err := attachJournal(input, hcmd)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := closeJournal(); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}()
 
 	isStdoutNormalFile := isFileOutput(os.Stdout)
-	sink := openOutput(os.Stdout, os.Stdout, runFlagVals.outfmt)
+	cols, err := parseColumns(runFlagVals.cols, scp, hctx.Funcs)
+	if err != nil {
+		return err
+	}
+	sink := openOutput(os.Stdout, os.Stdout, runFlagVals.outfmt, runFlagVals.emit, cols, binds, inputIds, runFlagVals.srcField)
 	if fn := runFlagVals.failfile; fn != "" && fn != "-" {
 		f, err := os.Create(fn)
 		if err != nil {
@@ -53,16 +73,22 @@ func cmdRun(cmd *cobra.Command, args []string) (finalErr error) {
 		}()
 		sink.Err = f
 	}
-	defer sink.Finish()
+	defer func() {
+		if err := sink.Finish(); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}()
 
 	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancelDeadline := withDeadline(ctx)
+	defer cancelDeadline()
 
 	enableProgress := runFlagVals.progress == "true" || (runFlagVals.progress == "auto" && isStdoutNormalFile)
-	input, outCounter, awaitProgressLogger := attachProgressLogger(ctx, input, enableProgress, maxInputBufferRecords, time.Second/4, os.Stderr)
+	input, outCounter, awaitProgressLogger := attachProgressLogger(ctx, input, enableProgress, commonFlagVals.inputBuffer, spillOptions(), time.Second/4, os.Stderr)
 	defer awaitProgressLogger()
 
-	attachInterruptForHttpRunner(ctx, hcmd.SetDryRun, cancel)
+	attachInterruptForHttpRunner(ctx, hcmdRaw.SetDryRun, cancel)
 
 	defer cancel()
-	return command.RunParallel(ctx, hcmd, binds, input, sink, runFlagVals.parallel, outCounter)
+	return runParallel(ctx, hcmd, binds, input, sink, outCounter)
 }
@@ -4,12 +4,18 @@ go 1.23.10
 
 require (
 	github.com/MercuryEngineering/CookieMonster v0.0.0-20180304172713-1584578b3403
+	github.com/andybalholm/brotli v1.2.0
 	github.com/daboyuka/kooky v0.2.5
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/klauspost/compress v1.18.0
 	github.com/schollz/progressbar/v3 v3.17.1
 	github.com/spf13/cobra v1.6.1
 	github.com/spf13/pflag v1.0.6
+	github.com/ulikunitz/xz v0.5.15
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	golang.org/x/net v0.42.0
 	golang.org/x/term v0.33.0
+	golang.org/x/time v0.12.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -27,6 +33,8 @@ require (
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	github.com/zalando/go-keyring v0.2.6 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/sys v0.34.0 // indirect
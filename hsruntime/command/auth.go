@@ -0,0 +1,357 @@
+package command
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/daboyuka/hs/hsruntime"
+	"github.com/daboyuka/hs/program/expr"
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// AuthSchemes are the schemes of auth specs (see RunOptions.Auth).
+var AuthSchemes = []string{"basic", "bearer", "digest"}
+
+// authTemplate is a parsed auth spec "SCHEME:CREDENTIALS", with templated credentials: "user:pass" for basic and digest,
+// or a token for bearer.
+type authTemplate struct {
+	scheme      string
+	credentials expr.Expr
+}
+
+func parseAuth(spec string, scp *scope.Scope, hctx *hsruntime.Context) (authTemplate, error) {
+	scheme, creds, ok := strings.Cut(spec, ":")
+	scheme = strings.ToLower(scheme)
+	if !ok || !slices.Contains(AuthSchemes, scheme) {
+		return authTemplate{}, fmt.Errorf("bad auth '%s': expected SCHEME:CREDENTIALS, with scheme one of: %s", redactAuth(spec), strings.Join(AuthSchemes, " "))
+	}
+	credsExpr, err := parser.ParseTemplate(creds, scp, hctx.Funcs)
+	if err != nil {
+		return authTemplate{}, fmt.Errorf("bad auth credentials: %w", err)
+	}
+	return authTemplate{scheme: scheme, credentials: credsExpr}, nil
+}
+
+// redactAuth returns auth spec with its credentials redacted, for error messages.
+func redactAuth(spec string) string {
+	if scheme, _, ok := strings.Cut(spec, ":"); ok {
+		return scheme + ":xxxxx"
+	}
+	return spec
+}
+
+// credentials are auth credentials for a request.
+type credentials struct {
+	scheme         string // one of AuthSchemes
+	user, password string // for basic and digest
+	token          string // for bearer
+}
+
+func (a authTemplate) eval(in record.Record, binds *scope.Bindings) (*credentials, error) {
+	credsStr, err := expr.EvalToString(a.credentials, in, binds)
+	if err != nil {
+		return nil, err
+	}
+	creds := &credentials{scheme: a.scheme}
+	if a.scheme == "bearer" {
+		creds.token = credsStr
+	} else if creds.user, creds.password, _ = strings.Cut(credsStr, ":"); creds.user == "" {
+		return nil, fmt.Errorf("empty %s auth user", a.scheme)
+	}
+	return creds, nil
+}
+
+// authorize sets the Authorization header of req for basic and bearer credentials; digest credentials are sent only in
+// response to a challenge (see httpRunner.send).
+func (c *credentials) authorize(req RequestAndBody) {
+	switch c.scheme {
+	case "basic":
+		req.SetBasicAuth(c.user, c.password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// httpAuth selects and evaluates credentials for requests.
+type httpAuth struct {
+	all   *authTemplate           // if non-nil, for all requests
+	hosts map[string]authTemplate // host (hostname or hostname:port, lowercase) -> auth, if all is nil
+
+	digestMu         sync.Mutex
+	digestChallenges map[digestSpace]*digestChallenge // latest Digest challenge for each host and realm
+	digestRealms     map[string]string                // host, and host + path directory, -> realm last challenged for it
+}
+
+// digestSpace identifies a Digest challenge cached for reuse: the host it is from, and its realm.
+type digestSpace struct{ host, realm string }
+
+func (a *httpAuth) init(spec string, scp *scope.Scope, hctx *hsruntime.Context) error {
+	if spec != "" {
+		auth, err := parseAuth(spec, scp, hctx)
+		if err != nil {
+			return err
+		}
+		a.all = &auth
+	}
+	a.hosts = make(map[string]authTemplate, len(hctx.Auth))
+	for host, spec := range hctx.Auth {
+		auth, err := parseAuth(spec, scp, hctx)
+		if err != nil {
+			return fmt.Errorf("bad AUTH for %s: %w", host, err)
+		}
+		a.hosts[host] = auth
+	}
+	a.digestChallenges = make(map[digestSpace]*digestChallenge)
+	a.digestRealms = make(map[string]string)
+	return nil
+}
+
+// credentials returns the credentials for req, built from input record in, or nil if none apply (including if req already
+// has an Authorization header).
+func (a *httpAuth) credentials(req RequestAndBody, in record.Record, binds *scope.Bindings) (*credentials, error) {
+	if req.Header.Get("Authorization") != "" {
+		return nil, nil
+	}
+	auth := a.all
+	if auth == nil {
+		if hostAuth, ok := a.hosts[strings.ToLower(req.URL.Host)]; ok {
+			auth = &hostAuth
+		} else if hostAuth, ok := a.hosts[strings.ToLower(req.URL.Hostname())]; ok {
+			auth = &hostAuth
+		} else {
+			return nil, nil
+		}
+	}
+	creds, err := auth.eval(in, binds)
+	if err != nil {
+		return nil, fmt.Errorf("auth error: %w", err)
+	}
+	return creds, nil
+}
+
+// digestChallenge returns the cached Digest challenge to answer for a request to u, or nil if none: that of the realm last
+// challenged for u's path directory on its host, or else for its host.
+func (a *httpAuth) digestChallenge(u *url.URL) *digestChallenge {
+	a.digestMu.Lock()
+	defer a.digestMu.Unlock()
+	realm, ok := a.digestRealms[u.Host+digestDir(u.Path)]
+	if !ok {
+		if realm, ok = a.digestRealms[u.Host]; !ok {
+			return nil
+		}
+	}
+	return a.digestChallenges[digestSpace{host: u.Host, realm: realm}]
+}
+
+// setDigestChallenge caches c, as challenged for a request to u.
+func (a *httpAuth) setDigestChallenge(u *url.URL, c *digestChallenge) {
+	a.digestMu.Lock()
+	defer a.digestMu.Unlock()
+	a.digestChallenges[digestSpace{host: u.Host, realm: c.realm}] = c
+	a.digestRealms[u.Host+digestDir(u.Path)] = c.realm
+	a.digestRealms[u.Host] = c.realm
+}
+
+// digestDir returns the directory of URL path p, including the trailing slash.
+func digestDir(p string) string {
+	return p[:strings.LastIndexByte(p, '/')+1]
+}
+
+// send sends req (one attempt), answering an HTTP Digest challenge if creds are digest credentials. The latest challenge
+// for each host and realm is reused for later requests to it, counting uses of its nonce, so usually only the first
+// request to a realm is sent twice. If a reused challenge is rejected (e.g. its nonce went stale, or, with parallel
+// requests, nonce counts arrived out of order), the request is sent again once, answering the new challenge.
+func (h *httpRunner) send(req RequestAndBody, creds *credentials) (*http.Response, error) {
+	digest := creds != nil && creds.scheme == "digest"
+	for challenged := false; ; challenged = true {
+		if req.GetBody != nil { // (re)open Body for each send
+			var err error
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("failed to open request body: %w", err)
+			}
+		}
+
+		if digest {
+			if c := h.auth.digestChallenge(req.URL); c != nil {
+				req.Header.Set("Authorization", c.authorize(creds, req.Method, req.URL.RequestURI()))
+			}
+		}
+
+		if err := h.limiter.Wait(req.Context(), req.URL); err != nil {
+			return nil, err
+		}
+		resp, err := h.client.Do(req.Request)
+		if err != nil || !digest || challenged || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err // (if challenged, credentials answering a new challenge were rejected)
+		}
+
+		c := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+		if c == nil {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		h.auth.setDigestChallenge(req.URL, c)
+	}
+}
+
+// digestChallenge is an HTTP Digest challenge (RFC 7616), as from a WWW-Authenticate header.
+type digestChallenge struct {
+	realm, nonce, opaque string
+	algorithm            string // as given (e.g. "SHA-256-sess"); "" if not given (MD5)
+	qop                  string // "auth", or "" if not given (RFC 2069 compatibility)
+
+	hash func() hash.Hash
+	sess bool
+
+	nc atomic.Uint32 // times nonce was used
+}
+
+var digestHashes = map[string]func() hash.Hash{"": md5.New, "MD5": md5.New, "SHA-256": sha256.New, "SHA-512-256": sha512.New512_256}
+
+// parseDigestChallenge returns the first supported Digest challenge in WWW-Authenticate header values, or nil if none.
+func parseDigestChallenge(values []string) *digestChallenge {
+	for _, v := range values {
+		for _, ch := range parseAuthChallenges(v) {
+			if !strings.EqualFold(ch.scheme, "digest") || ch.params["nonce"] == "" {
+				continue
+			}
+
+			c := &digestChallenge{
+				realm:     ch.params["realm"],
+				nonce:     ch.params["nonce"],
+				opaque:    ch.params["opaque"],
+				algorithm: ch.params["algorithm"],
+			}
+			alg, sess := strings.CutSuffix(strings.ToUpper(c.algorithm), "-SESS")
+			if c.hash, c.sess = digestHashes[alg], sess; c.hash == nil {
+				continue // unsupported algorithm
+			}
+			if qops, ok := ch.params["qop"]; ok {
+				if !slices.ContainsFunc(strings.Split(qops, ","), func(qop string) bool { return strings.TrimSpace(qop) == "auth" }) {
+					continue // only auth-int (unsupported)
+				}
+				c.qop = "auth"
+			}
+			return c
+		}
+	}
+	return nil
+}
+
+// authorize returns an Authorization header value for a request with method and uri, with a new client nonce.
+func (c *digestChallenge) authorize(creds *credentials, method, uri string) string {
+	var b [16]byte
+	_, _ = rand.Read(b[:]) // never returns an error
+	cnonce := hex.EncodeToString(b[:])
+	nc := fmt.Sprintf("%08x", c.nc.Add(1))
+
+	hdr := fmt.Sprintf(`Digest username=%s, realm=%s, nonce=%s, uri=%s, response=%s`,
+		quote(creds.user), quote(c.realm), quote(c.nonce), quote(uri), quote(c.response(creds, method, uri, cnonce, nc)))
+	if c.algorithm != "" {
+		hdr += ", algorithm=" + c.algorithm
+	}
+	if c.qop != "" {
+		hdr += fmt.Sprintf(", qop=%s, nc=%s, cnonce=%s", c.qop, nc, quote(cnonce))
+	}
+	if c.opaque != "" {
+		hdr += ", opaque=" + quote(c.opaque)
+	}
+	return hdr
+}
+
+// response returns the Digest response value for a request with method and uri, given client nonce cnonce and nonce
+// count nc.
+func (c *digestChallenge) response(creds *credentials, method, uri, cnonce, nc string) string {
+	h := func(parts ...string) string {
+		hh := c.hash()
+		hh.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	ha1 := h(creds.user, c.realm, creds.password)
+	if c.sess {
+		ha1 = h(ha1, c.nonce, cnonce)
+	}
+	ha2 := h(method, uri)
+	if c.qop == "" {
+		return h(ha1, c.nonce, ha2)
+	}
+	return h(ha1, c.nonce, nc, cnonce, c.qop, ha2)
+}
+
+// quote returns s as an HTTP quoted-string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// authChallenge is a challenge from a WWW-Authenticate header.
+type authChallenge struct {
+	scheme string
+	params map[string]string // lowercase name -> value
+}
+
+// parseAuthChallenges parses a WWW-Authenticate header value: a comma-separated list of challenges, each a scheme
+// followed by comma-separated params (name=token or name="quoted string"). Malformed input is parsed leniently.
+func parseAuthChallenges(s string) (out []authChallenge) {
+	const sep = " \t,="
+	for i := 0; i < len(s); {
+		// Skip separators, then read a token: a scheme, or a param name (if followed by =)
+		if strings.IndexByte(" \t,", s[i]) >= 0 {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && strings.IndexByte(sep, s[i]) < 0 {
+			i++
+		}
+		tok := s[start:i]
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if tok == "" || i >= len(s) || s[i] != '=' {
+			if tok == "" {
+				i++ // stray =
+			} else {
+				out = append(out, authChallenge{scheme: tok, params: make(map[string]string)})
+			}
+			continue
+		}
+
+		// Param value
+		for i++; i < len(s) && (s[i] == ' ' || s[i] == '\t'); i++ {
+		}
+		var val strings.Builder
+		if i < len(s) && s[i] == '"' {
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				val.WriteByte(s[i])
+			}
+			i++ // closing quote
+		} else {
+			for ; i < len(s) && s[i] != ',' && s[i] != ' ' && s[i] != '\t'; i++ {
+				val.WriteByte(s[i])
+			}
+		}
+		if len(out) > 0 {
+			out[len(out)-1].params[strings.ToLower(tok)] = val.String()
+		}
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package command
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseAuthChallenges(t *testing.T) {
+	tests := []struct {
+		Src    string
+		Expect []authChallenge
+	}{
+		{Src: `Basic realm="x"`, Expect: []authChallenge{{scheme: "Basic", params: map[string]string{"realm": "x"}}}},
+		{
+			Src: `Digest realm="a, \"b\"", qop="auth,auth-int", NONCE=abc, Basic realm=y`,
+			Expect: []authChallenge{
+				{scheme: "Digest", params: map[string]string{"realm": `a, "b"`, "qop": "auth,auth-int", "nonce": "abc"}},
+				{scheme: "Basic", params: map[string]string{"realm": "y"}},
+			},
+		},
+		{Src: `Negotiate, Bearer`, Expect: []authChallenge{{scheme: "Negotiate", params: map[string]string{}}, {scheme: "Bearer", params: map[string]string{}}}},
+	}
+	for _, tst := range tests {
+		if got := parseAuthChallenges(tst.Src); !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': expected %+v, got %+v", tst.Src, tst.Expect, got)
+		}
+	}
+}
+
+func TestDigestResponse(t *testing.T) {
+	// Examples from RFC 7616 section 3.9.1
+	const hdr = `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=%s, ` +
+		`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	creds := &credentials{scheme: "digest", user: "Mufasa", password: "Circle of Life"}
+	for alg, expect := range map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	} {
+		c := parseDigestChallenge([]string{fmt.Sprintf(hdr, alg)})
+		if c == nil {
+			t.Errorf("%s: challenge not parsed", alg)
+			continue
+		}
+		if got := c.response(creds, "GET", "/dir/index.html", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", "00000001"); got != expect {
+			t.Errorf("%s: expected response %s, got %s", alg, expect, got)
+		}
+	}
+
+	if c := parseDigestChallenge([]string{`Digest realm="x", nonce="n", qop="auth-int"`, `Digest realm="x", nonce="n", algorithm=SHA-1`}); c != nil {
+		t.Errorf("expected unsupported challenges to be skipped, got %+v", c)
+	}
+}
+
+// digestServer is a test server requiring Digest auth, with realm "r" + the first path segment (e.g. "rx" for /x/1), and a
+// new nonce per challenge. Like some servers, it rejects nonce counts not greater than the last accepted for the nonce.
+type digestServer struct {
+	*httptest.Server
+	requests atomic.Int32
+
+	mu      sync.Mutex
+	nonces  int
+	lastNCs map[string]int64 // nonce -> last accepted nonce count
+}
+
+func newDigestServer(user, pass string) *digestServer {
+	md5hex := func(s string) string { sum := md5.Sum([]byte(s)); return hex.EncodeToString(sum[:]) }
+	s := &digestServer{lastNCs: make(map[string]int64)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		realm := "r" + strings.Split(r.URL.Path, "/")[1]
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if chs := parseAuthChallenges(r.Header.Get("Authorization")); len(chs) == 1 && chs[0].scheme == "Digest" {
+			p := chs[0].params
+			ha1, ha2 := md5hex(user+":"+realm+":"+pass), md5hex(r.Method+":"+r.URL.RequestURI())
+			nc, _ := strconv.ParseInt(p["nc"], 16, 64)
+			lastNC, ok := s.lastNCs[p["nonce"]]
+			if ok && nc > lastNC && p["realm"] == realm && p["uri"] == r.URL.RequestURI() &&
+				p["response"] == md5hex(strings.Join([]string{ha1, p["nonce"], p["nc"], p["cnonce"], "auth", ha2}, ":")) {
+				s.lastNCs[p["nonce"]] = nc
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		s.nonces++
+		nonce := fmt.Sprintf("n%d", s.nonces)
+		s.lastNCs[nonce] = 0
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth"`, realm, nonce))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	return s
+}
+
+// skipNCs makes the server reject the next n nonce counts of all nonces, as if used by requests that arrived first.
+func (s *digestServer) skipNCs(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for nonce := range s.lastNCs {
+		s.lastNCs[nonce] += n
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	const user, pass = "me", "secret"
+	srv := newDigestServer(user, pass)
+	defer srv.Close()
+
+	h := &httpRunner{client: srv.Client()}
+	h.auth.digestChallenges = make(map[digestSpace]*digestChallenge)
+	h.auth.digestRealms = make(map[string]string)
+
+	creds := &credentials{scheme: "digest", user: user, password: pass}
+	tests := []struct {
+		Name     string
+		Path     string
+		Creds    *credentials
+		Skip     int64 // nonce counts to skip before sending (see skipNCs)
+		Status   int
+		Requests int32
+	}{
+		{Name: "first request", Path: "/x/a?q=1", Creds: creds, Status: 200, Requests: 2},
+		{Name: "cached challenge", Path: "/x/b", Creds: creds, Status: 200, Requests: 1},
+		{Name: "other realm", Path: "/y/a", Creds: creds, Status: 200, Requests: 2},
+		{Name: "cached challenge, by directory", Path: "/x/c", Creds: creds, Status: 200, Requests: 1},
+		{Name: "cached challenge, other realm", Path: "/y/b", Creds: creds, Status: 200, Requests: 1},
+		{Name: "nonce count out of order", Path: "/y/c", Creds: creds, Skip: 5, Status: 200, Requests: 2},
+		{Name: "bad password", Path: "/x/d", Creds: &credentials{scheme: "digest", user: user, password: "wrong"}, Status: 401, Requests: 2},
+		{Name: "bad password, no challenge cached", Path: "/z/a", Creds: &credentials{scheme: "digest", user: user, password: "wrong"}, Status: 401, Requests: 2},
+	}
+	for _, tst := range tests {
+		srv.skipNCs(tst.Skip)
+		before := srv.requests.Load()
+
+		httpReq, err := http.NewRequestWithContext(context.Background(), "POST", srv.URL+tst.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := RequestAndBody{Request: httpReq}
+		bodyStrToRequestBody("body", &req) // re-sent after the challenge
+		resp, err := h.send(req, tst.Creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if requests := srv.requests.Load() - before; resp.StatusCode != tst.Status || requests != tst.Requests {
+			t.Errorf("test '%s': expected %d after %d requests, got %d after %d", tst.Name, tst.Status, tst.Requests, resp.StatusCode, requests)
+		}
+	}
+}
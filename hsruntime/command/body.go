@@ -0,0 +1,193 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daboyuka/hs/hsruntime"
+	"github.com/daboyuka/hs/program/expr"
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// FormField is a field of a multipart/form-data request body: either a value, or (if File is set) the content of a file.
+type FormField struct {
+	Name  string
+	Value string // if File is empty
+	File  string // path of a file to upload
+}
+
+// formFieldTemplate is a parsed form field source 'name=value' or 'name=@path'.
+type formFieldTemplate struct {
+	name   string
+	value  expr.Expr
+	isFile bool
+}
+
+// parseFormField parses a form field source. The name is literal; the value (or file path) is a template. As with
+// bodies, a value beginning with '@' is a file path, and '@@' begins a literal '@'.
+func parseFormField(src string, scp *scope.Scope, hctx *hsruntime.Context) (field formFieldTemplate, err error) {
+	name, valSrc, ok := strings.Cut(src, "=")
+	if !ok || name == "" {
+		return formFieldTemplate{}, fmt.Errorf("form field must be 'name=value' or 'name=@file': %s", src)
+	}
+	field.name = name
+	valSrc, field.isFile = cutFileRef(valSrc)
+	if field.value, err = parser.ParseTemplate(valSrc, scp, hctx.Funcs); err != nil {
+		return formFieldTemplate{}, fmt.Errorf("bad form field '%s': %w", name, err)
+	}
+	return field, nil
+}
+
+func (f formFieldTemplate) eval(in record.Record, binds *scope.Bindings) (FormField, error) {
+	v, err := expr.EvalToString(f.value, in, binds)
+	if err != nil {
+		return FormField{}, err
+	} else if f.isFile {
+		if v == "" {
+			return FormField{}, fmt.Errorf("empty file path for form field '%s'", f.name)
+		}
+		return FormField{Name: f.name, File: v}, nil
+	}
+	return FormField{Name: f.name, Value: v}, nil
+}
+
+// cutFileRef returns whether template src is a file reference ('@path'), and the remaining template: the path if so, or
+// otherwise src with a leading '@@' unescaped to '@'.
+func cutFileRef(src string) (rest string, isFile bool) {
+	if strings.HasPrefix(src, "@@") {
+		return src[1:], false
+	} else if path, ok := strings.CutPrefix(src, "@"); ok {
+		return path, true
+	}
+	return src, false
+}
+
+// fileToRequestBody sets req's body to stream the file at path, opened anew for each attempt.
+func fileToRequestBody(path string, req *RequestAndBody) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("request body file: %w", err)
+	}
+	req.BodyFile = path
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(path) }
+	req.ContentLength = info.Size()
+	return nil
+}
+
+// formToRequestBody sets req's body to stream a multipart/form-data body of fields, and sets its Content-Type. If req
+// already has a Content-Type with a boundary (e.g. from a built request record), that boundary is used.
+func formToRequestBody(fields []FormField, req *RequestAndBody) error {
+	var boundary string
+	if _, params, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err == nil {
+		boundary = params["boundary"]
+	}
+	if boundary == "" {
+		boundary = multipart.NewWriter(nil).Boundary() // random
+		req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	}
+
+	// Compute the length without reading files: the multipart framing, with empty file contents, plus the file sizes
+	var fileSizes int64
+	for _, f := range fields {
+		if f.File != "" {
+			info, err := os.Stat(f.File)
+			if err != nil {
+				return fmt.Errorf("form field '%s' file: %w", f.Name, err)
+			}
+			fileSizes += info.Size()
+		}
+	}
+	framing := countingWriter(0)
+	if err := writeForm(&framing, boundary, fields, false); err != nil {
+		return err
+	}
+
+	req.FormFields = fields
+	req.GetBody = func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(writeForm(pw, boundary, fields, true)) }()
+		return pr, nil
+	}
+	req.ContentLength = int64(framing) + fileSizes
+	return nil
+}
+
+// writeForm writes a multipart/form-data body of fields to w. If withFiles is false, file contents are omitted.
+func writeForm(w io.Writer, boundary string, fields []FormField, withFiles bool) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.File == "" {
+			if err := mw.WriteField(f.Name, f.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		part, err := mw.CreateFormFile(f.Name, filepath.Base(f.File))
+		if err != nil {
+			return err
+		} else if withFiles {
+			if err := copyFile(part, f.File); err != nil {
+				return fmt.Errorf("form field '%s' file: %w", f.Name, err)
+			}
+		}
+	}
+	return mw.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+func formToRecord(fields []FormField) record.Array {
+	out := make(record.Array, len(fields))
+	for i, f := range fields {
+		if f.File != "" {
+			out[i] = record.Object{"name": f.Name, "file": f.File}
+		} else {
+			out[i] = record.Object{"name": f.Name, "value": f.Value}
+		}
+	}
+	return out
+}
+
+func formFromRecord(rec record.Record) (fields []FormField, err error) {
+	arr, ok := rec.(record.Array)
+	if !ok {
+		return nil, fmt.Errorf("non-array form in record: %s", record.CoerceString(rec))
+	}
+	for _, elem := range arr {
+		obj, _ := elem.(record.Object)
+		name, nameOk := obj["name"].(string)
+		value, valueOk := obj["value"].(string)
+		file, fileOk := obj["file"].(string)
+		if !nameOk || valueOk == fileOk || (fileOk && file == "") {
+			return nil, fmt.Errorf("malformed form field in record (expected {name, value} or {name, file}): %s", record.CoerceString(elem))
+		}
+		fields = append(fields, FormField{Name: name, Value: value, File: file})
+	}
+	return fields, nil
+}
@@ -0,0 +1,84 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCutFileRef(t *testing.T) {
+	tests := []struct {
+		Src, Rest string
+		IsFile    bool
+	}{
+		{Src: "@path/${.name}.json", Rest: "path/${.name}.json", IsFile: true},
+		{Src: "@@literal", Rest: "@literal"},
+		{Src: "body", Rest: "body"},
+		{Src: "", Rest: ""},
+	}
+	for _, tst := range tests {
+		if rest, isFile := cutFileRef(tst.Src); rest != tst.Rest || isFile != tst.IsFile {
+			t.Errorf("test '%s': expected ('%s', %v), got ('%s', %v)", tst.Src, tst.Rest, tst.IsFile, rest, isFile)
+		}
+	}
+}
+
+func TestFormToRequestBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	if err := os.WriteFile(path, []byte("file content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fields := []FormField{{Name: "file", File: path}, {Name: "meta", Value: "some value"}}
+
+	req := RequestAndBody{Request: &http.Request{Header: make(http.Header)}}
+	if err := formToRequestBody(fields, &req); err != nil {
+		t.Fatal(err)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != req.ContentLength {
+		t.Errorf("expected ContentLength %d, got %d", len(data), req.ContentLength)
+	}
+
+	// Parse it back
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(bytes.NewReader(data), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := form.Value["meta"]; !reflect.DeepEqual(v, []string{"some value"}) {
+		t.Errorf("expected meta value, got %v", v)
+	}
+	if f := form.File["file"]; len(f) != 1 || f[0].Filename != "upload.txt" || f[0].Size != int64(len("file content")) {
+		t.Errorf("expected file upload.txt, got %v", f)
+	}
+
+	// Round trip through a record, reusing the boundary
+	fields2, err := formFromRecord(formToRecord(fields))
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(fields, fields2) {
+		t.Errorf("expected %v, got %v", fields, fields2)
+	}
+	req2 := RequestAndBody{Request: &http.Request{Header: req.Header.Clone()}}
+	if err := formToRequestBody(fields2, &req2); err != nil {
+		t.Fatal(err)
+	} else if req2.Header.Get("Content-Type") != req.Header.Get("Content-Type") || req2.ContentLength != req.ContentLength {
+		t.Errorf("expected same Content-Type and length after round trip")
+	}
+}
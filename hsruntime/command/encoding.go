@@ -0,0 +1,136 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ContentEncodings are the response Content-Encodings that may be accepted (and are decoded).
+var ContentEncodings = []string{"gzip", "br", "zstd", "deflate"}
+
+// RequestContentEncodings are the Content-Encodings with which request bodies may be compressed.
+var RequestContentEncodings = []string{"gzip", "zstd"}
+
+// ParseAcceptEncoding parses a comma-separated list of ContentEncodings, returning an Accept-Encoding header value. The
+// empty list accepts only uncompressed responses ("identity").
+func ParseAcceptEncoding(src string) (string, error) {
+	var encs []string
+	for _, enc := range strings.Split(src, ",") {
+		if enc = strings.ToLower(strings.TrimSpace(enc)); enc == "" || enc == "identity" {
+			continue
+		} else if !slices.Contains(ContentEncodings, enc) {
+			return "", fmt.Errorf("unsupported content encoding '%s' (expected one of: %s)", enc, strings.Join(ContentEncodings, " "))
+		}
+		encs = append(encs, enc)
+	}
+	if len(encs) == 0 {
+		return "identity", nil
+	}
+	return strings.Join(encs, ", "), nil
+}
+
+// decodeContentEncoding returns a reader decoding r per Content-Encoding header value contentEnc (a list of encodings,
+// in the order applied). If any encoding is unsupported, r is returned as-is.
+func decodeContentEncoding(contentEnc string, r io.Reader) (io.Reader, error) {
+	var encs []string
+	for _, enc := range strings.Split(contentEnc, ",") {
+		if enc = strings.ToLower(strings.TrimSpace(enc)); enc != "" && enc != "identity" {
+			if enc == "x-gzip" {
+				enc = "gzip"
+			}
+			if !slices.Contains(ContentEncodings, enc) {
+				return r, nil
+			}
+			encs = append(encs, enc)
+		}
+	}
+
+	for i := len(encs) - 1; i >= 0; i-- {
+		var err error
+		switch encs[i] {
+		case "gzip":
+			r, err = gzip.NewReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		case "zstd":
+			r, err = zstd.NewReader(r, zstd.WithDecoderConcurrency(1)) // decodes synchronously, so needn't be closed
+		case "deflate":
+			r, err = newDeflateReader(r)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", encs[i], err)
+		}
+	}
+	return r, nil
+}
+
+// newDeflateReader decodes "deflate" content, which should be zlib-wrapped, but is raw deflate from some servers.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if hdr, _ := br.Peek(2); len(hdr) == 2 && hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// compressRequestBody compresses req's body with Content-Encoding enc (one of RequestContentEncodings). An in-memory
+// body is compressed in full, so its length is known; a streamed body (file or form) is compressed as it is sent, with
+// unknown length (so is sent chunked).
+func compressRequestBody(enc string, req *RequestAndBody) error {
+	if req.BodyFile == "" && req.FormFields == nil {
+		var buf bytes.Buffer
+		if err := compress(enc, &buf, strings.NewReader(req.BodyContent)); err != nil {
+			return err
+		}
+		data := buf.Bytes()
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+		req.ContentLength = int64(len(data))
+		return nil
+	}
+
+	getBody := req.GetBody
+	req.GetBody = func() (io.ReadCloser, error) {
+		body, err := getBody()
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer body.Close()
+			pw.CloseWithError(compress(enc, pw, body))
+		}()
+		return pr, nil
+	}
+	req.ContentLength = -1
+	return nil
+}
+
+// compress writes r to w, compressed with Content-Encoding enc.
+func compress(enc string, w io.Writer, r io.Reader) (err error) {
+	var cw io.WriteCloser
+	switch enc {
+	case "gzip":
+		cw = gzip.NewWriter(w)
+	case "zstd":
+		if cw, err = zstd.NewWriter(w, zstd.WithEncoderConcurrency(1)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported request content encoding '%s'", enc)
+	}
+	if _, err = io.Copy(cw, r); err != nil {
+		_ = cw.Close()
+		return err
+	}
+	return cw.Close()
+}
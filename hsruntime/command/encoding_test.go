@@ -0,0 +1,156 @@
+package command
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/daboyuka/hs/program/record"
+)
+
+func TestDecodeContentEncoding(t *testing.T) {
+	const data = "some response body"
+
+	compress := func(src []byte, newWriter func(w io.Writer) (io.WriteCloser, error)) []byte {
+		var buf bytes.Buffer
+		w, err := newWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(src)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	gzipW := func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+	brW := func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil }
+	zstdW := func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+	zlibW := func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil }
+	flateW := func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) }
+
+	tests := []struct {
+		Name  string
+		Enc   string
+		Input []byte
+	}{
+		{Name: "identity", Enc: "", Input: []byte(data)},
+		{Name: "gzip", Enc: "gzip", Input: compress([]byte(data), gzipW)},
+		{Name: "x-gzip", Enc: "x-gzip", Input: compress([]byte(data), gzipW)},
+		{Name: "br", Enc: "br", Input: compress([]byte(data), brW)},
+		{Name: "zstd", Enc: "zstd", Input: compress([]byte(data), zstdW)},
+		{Name: "deflate (zlib)", Enc: "deflate", Input: compress([]byte(data), zlibW)},
+		{Name: "deflate (raw)", Enc: "deflate", Input: compress([]byte(data), flateW)},
+		{Name: "multiple", Enc: "gzip, br", Input: compress(compress([]byte(data), gzipW), brW)},
+	}
+	for _, tst := range tests {
+		r, err := decodeContentEncoding(tst.Enc, bytes.NewReader(tst.Input))
+		if err != nil {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+			continue
+		}
+		if out, err := io.ReadAll(r); err != nil {
+			t.Errorf("test '%s': unexpected read error: %v", tst.Name, err)
+		} else if string(out) != data {
+			t.Errorf("test '%s': expected '%s', got '%s'", tst.Name, data, out)
+		}
+	}
+
+	// Unsupported encodings are passed through
+	in := compress([]byte(data), gzipW)
+	if r, err := decodeContentEncoding("gzip, unknown", bytes.NewReader(in)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if out, _ := io.ReadAll(r); !bytes.Equal(out, in) {
+		t.Errorf("expected unsupported encoding to pass through")
+	}
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		Src, Expect string
+		WantErr     bool
+	}{
+		{Src: "gzip,br,zstd,deflate", Expect: "gzip, br, zstd, deflate"},
+		{Src: " GZIP ", Expect: "gzip"},
+		{Src: "", Expect: "identity"},
+		{Src: "identity", Expect: "identity"},
+		{Src: "gzip,compress", WantErr: true},
+	}
+	for _, tst := range tests {
+		v, err := ParseAcceptEncoding(tst.Src)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%s': unexpected error: %v", tst.Src, err)
+		} else if v != tst.Expect {
+			t.Errorf("test '%s': expected '%s', got '%s'", tst.Src, tst.Expect, v)
+		}
+	}
+}
+
+func TestCompressRequestBody(t *testing.T) {
+	const data = "some request body"
+	for _, enc := range RequestContentEncodings {
+		req := RequestAndBody{Request: &http.Request{Header: make(http.Header)}}
+		bodyStrToRequestBody(data, &req)
+		if err := compressRequestBody(enc, &req); err != nil {
+			t.Fatalf("%s: %v", enc, err)
+		}
+
+		body, err := req.GetBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, _ := io.ReadAll(body)
+		if int64(len(compressed)) != req.ContentLength {
+			t.Errorf("%s: expected ContentLength %d, got %d", enc, len(compressed), req.ContentLength)
+		}
+		r, err := decodeContentEncoding(enc, bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := io.ReadAll(r); string(out) != data {
+			t.Errorf("%s: expected '%s', got '%s'", enc, data, out)
+		}
+	}
+}
+
+func TestRunCompressBody(t *testing.T) {
+	const data = `{"a":1}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := decodeContentEncoding(r.Header.Get("Content-Encoding"), r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, _ := io.ReadAll(body)
+		_, _ = w.Write([]byte(r.Header.Get("Content-Encoding") + ":" + string(b)))
+	}))
+	defer srv.Close()
+
+	h := &httpRunner{client: srv.Client(), acceptEncoding: "gzip", compressBody: "gzip"}
+	httpReq, _ := http.NewRequest("POST", srv.URL, nil)
+	req := RequestAndBody{Request: httpReq}
+	bodyStrToRequestBody(data, &req)
+
+	out, err := h.run(context.Background(), req, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, _ := out.Next()
+	recObj := rec.(record.Object)
+	if body := recObj["response"].(record.Object)["body"]; body != "gzip:"+data {
+		t.Errorf("expected server to receive gzipped body, got '%v'", body)
+	}
+	// The recorded request has the uncompressed body, so must not claim a Content-Encoding (e.g. to be rerun by hs run)
+	if hdrs, _ := recObj["headers"].(record.Object); hdrs["Content-Encoding"] != nil || recObj["body"] != data {
+		t.Errorf("expected recorded request with uncompressed body and no Content-Encoding, got %v", recObj)
+	}
+}
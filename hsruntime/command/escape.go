@@ -0,0 +1,86 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/daboyuka/hs/program/expr"
+)
+
+// urlEscapeContext escapes interpolations by their position in a URL template:
+//   - scheme and host (everything before the first '/', '?' or '#' following "scheme://"): not escaped
+//   - path: escaped as a path segment ('/' is escaped)
+//   - query: escaped as a query key/value ('&' and '=' are escaped)
+//   - fragment: escaped as a path segment
+func urlEscapeContext(prefix string) expr.Escaper {
+	if i := strings.IndexAny(prefix, ":/?#"); i != -1 && prefix[i] == ':' {
+		prefix = prefix[i+1:] // strip scheme
+	}
+	prefix = strings.TrimPrefix(prefix, "//")
+
+	switch i := strings.LastIndexAny(prefix, "/?#"); {
+	case i == -1:
+		return nil // still in host
+	case strings.Contains(prefix, "#"):
+		return url.PathEscape
+	case strings.Contains(prefix, "?"):
+		return url.QueryEscape
+	default:
+		return url.PathEscape
+	}
+}
+
+// headerEscapeContext escapes interpolations in a header template's value (after the first ':') by replacing control
+// characters (notably CR and LF) with spaces, so a value cannot break out into another header.
+func headerEscapeContext(prefix string) expr.Escaper {
+	if !strings.Contains(prefix, ":") {
+		return nil
+	}
+	return escapeHeaderValue
+}
+
+func escapeHeaderValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\t') || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// newBodyEscapeContext returns the EscapeContext for the body template src. If the template looks like JSON (begins
+// with '{' or '['), interpolations inside JSON string literals are escaped as string contents; others are not escaped.
+// Non-JSON bodies are not escaped.
+func newBodyEscapeContext(src string) expr.EscapeContext {
+	if trimmed := strings.TrimLeft(src, " \t\r\n"); trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return func(string) expr.Escaper { return nil }
+	}
+	return jsonEscapeContext
+}
+
+// jsonEscapeContext escapes interpolations that fall inside a JSON string literal.
+func jsonEscapeContext(prefix string) expr.Escaper {
+	inStr := false
+	for i := 0; i < len(prefix); i++ {
+		switch c := prefix[i]; {
+		case c == '"':
+			inStr = !inStr
+		case c == '\\' && inStr:
+			i++ // skip escaped char
+		}
+	}
+	if !inStr {
+		return nil
+	}
+	return escapeJSONString
+}
+
+func escapeJSONString(s string) string {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)                           // cannot fail for a string
+	return string(buf.Bytes()[1 : buf.Len()-2]) // strip quotes and trailing newline
+}
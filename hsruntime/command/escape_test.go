@@ -0,0 +1,41 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/daboyuka/hs/program/expr"
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+func TestTemplateEscaping(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Ctx    expr.EscapeContext
+		Tmpl   string
+		Expect string
+	}{
+		{Name: "URL path", Ctx: urlEscapeContext, Tmpl: `//example.com/item/${.v}`, Expect: `//example.com/item/a%2Fb%3Fc&d%22`},
+		{Name: "URL query value", Ctx: urlEscapeContext, Tmpl: `https://example.com/?x=1&y=${.v}`, Expect: `https://example.com/?x=1&y=a%2Fb%3Fc%26d%22`},
+		{Name: "URL fragment", Ctx: urlEscapeContext, Tmpl: `//example.com/?x=1#${.v}`, Expect: `//example.com/?x=1#a%2Fb%3Fc&d%22`},
+		{Name: "URL host", Ctx: urlEscapeContext, Tmpl: `https://${.h}/`, Expect: `https://ex.com:80/`},
+		{Name: "URL base", Ctx: urlEscapeContext, Tmpl: `${.u}/${.v}`, Expect: `https://ex.com/a/a%2Fb%3Fc&d%22`},
+		{Name: "URL raw", Ctx: urlEscapeContext, Tmpl: `//example.com/${raw .v}`, Expect: `//example.com/a/b?c&d"`},
+		{Name: "header", Ctx: headerEscapeContext, Tmpl: `X-${.v}: ${.nl}`, Expect: `X-a/b?c&d": a b`},
+		{Name: "JSON string", Ctx: newBodyEscapeContext(`{`), Tmpl: `{"a":"${.v}","b":${.n},"c\"":"${.nl}"}`, Expect: `{"a":"a/b?c&d\"","b":1,"c\"":"a\nb"}`},
+		{Name: "non-JSON body", Ctx: newBodyEscapeContext(`x`), Tmpl: `x="${.v}"`, Expect: `x="a/b?c&d""`},
+	}
+
+	rec := map[string]any{"v": `a/b?c&d"`, "h": "ex.com:80", "u": "https://ex.com/a", "nl": "a\nb", "n": 1.0}
+	for _, tst := range tests {
+		e, err := parser.ParseTemplate(tst.Tmpl, nil, scope.NewFuncTable(nil, nil))
+		if err != nil {
+			t.Fatalf("test '%s': parse error: %s", tst.Name, err)
+		}
+		if got, err := expr.EvalToString(expr.Escape(e, tst.Ctx), rec, nil); err != nil {
+			t.Errorf("test '%s' failed: eval error: %s", tst.Name, err)
+		} else if got != tst.Expect {
+			t.Errorf("test '%s' failed: got %s, expect %s", tst.Name, got, tst.Expect)
+		}
+	}
+}
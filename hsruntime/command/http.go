@@ -1,13 +1,17 @@
 package command
 
 import (
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,58 +20,154 @@ import (
 	"github.com/daboyuka/hs/hsruntime"
 	"github.com/daboyuka/hs/hsruntime/datafmt"
 	"github.com/daboyuka/hs/hsruntime/hostalias"
+	"github.com/daboyuka/hs/hsruntime/ratelimit"
 	"github.com/daboyuka/hs/program/expr"
 	"github.com/daboyuka/hs/program/expr/parser"
 	"github.com/daboyuka/hs/program/record"
 	"github.com/daboyuka/hs/program/scope"
 )
 
-type RetryFunc func(req RequestAndBody, resp ResponseAndBody, attempt int) (backoff time.Duration, retry bool)
+// RetryFunc decides whether to retry a request after response resp (or connection error) on the given attempt (0 for the
+// first retry), and if so, after what backoff delay.
+type RetryFunc func(req RequestAndBody, resp ResponseAndBody, attempt int) (backoff time.Duration, retry bool, err error)
 
 type RequestAndBody struct {
 	*http.Request
 	BodyContent string
+
+	BodyFile   string      // if non-empty, the body is streamed from this file, instead of BodyContent
+	FormFields []FormField // if non-nil, the body is multipart/form-data of these fields, instead of BodyContent
 }
 
 type ResponseAndBody struct {
 	*http.Response
 	BodyContent string
 	HTTPError   error
+	BodyError   error // if non-nil, BodyContent could not be decoded from its binary Content-Type, so is as received
+
+	SavedBody *SavedBody // if non-nil, the body was saved to a file, instead of held in BodyContent
+}
+
+// RunOptions are optional settings for running requests.
+type RunOptions struct {
+	Retry RetryFunc // if non-nil, decides whether to retry requests
+
+	// SaveBody, if non-empty, is a template for a file path, evaluated on each input record, to which successful (2xx)
+	// response bodies are streamed, instead of being held in the response record (see SavedBody).
+	SaveBody string
+
+	// AcceptEncoding, if non-empty, is the Accept-Encoding header value for requests without one (see
+	// ParseAcceptEncoding); otherwise, "gzip". Responses are decoded per their Content-Encoding.
+	AcceptEncoding string
+
+	// CompressBody, if non-empty, is a Content-Encoding (one of RequestContentEncodings) with which to compress request
+	// bodies, for requests with a body and no Content-Encoding header.
+	CompressBody string
+
+	// Auth, if non-empty, is an auth spec "SCHEME:CREDENTIALS" (SCHEME one of AuthSchemes; CREDENTIALS a template,
+	// evaluated on each input record, of "user:pass" for basic and digest, or a token for bearer) for all requests;
+	// otherwise, auth specs from config AUTH apply by host. Requests with an Authorization header are sent as-is.
+	Auth string
+}
+
+// BuildOptions are optional settings for building requests.
+type BuildOptions struct {
+	// BodyFormat, if not datafmt.Unknown, sets Content-Type for requests with a body and no Content-Type header, instead
+	// of autodetecting it.
+	BodyFormat datafmt.Format
+
+	// IdempotencyKey, if set, adds an Idempotency-Key header to requests without one, so that they may be safely retried
+	// (see RetryPolicy). The key is derived from the request's method and URL, its input record, and the record's source
+	// (see InputSource), so is the same when the input is rerun (e.g. resumed after a crash).
+	IdempotencyKey bool
+
+	// InputSource, if non-empty, are the variables bound to the source of each input record (e.g. its file and line),
+	// which distinguish identical input records in idempotency keys. Identical records with the same source (e.g. if
+	// line numbers are unknown) are distinguished by their occurrence number.
+	InputSource []scope.Ident
+
+	// Form, if non-empty, are multipart/form-data body fields, as templates 'name=value' or 'name=@path' (a file
+	// upload). Form and a body may not both be given.
+	Form []string
 }
 
 type httpBuilder struct {
-	method  string
-	url     expr.Expr
-	body    expr.Expr
-	headers []expr.Expr
+	method   string
+	url      expr.Expr
+	body     expr.Expr
+	bodyFile expr.Expr // if non-nil, the body is this file (body is nil)
+	form     []formFieldTemplate
+	headers  []expr.Expr
+	opts     BuildOptions
 
 	hostAliasing hostalias.HostAlias
 
 	autoContentTypeOnce sync.Once
 	autoContentType     string
+
+	idemMtx    sync.Mutex
+	idemCounts map[[sha256.Size]byte]int // occurrences of each idempotency key source so far
 }
 
 type httpRunner struct {
-	client *http.Client
-	retry  RetryFunc
+	client   *http.Client
+	limiter  *ratelimit.Limiter
+	retry    RetryFunc
+	saveBody expr.Expr // nil if bodies are not saved
+	auth     httpAuth
+
+	acceptEncoding string
+	compressBody   string
 
 	dryrun atomic.Bool
 }
 
+func (h *httpRunner) init(opts RunOptions, scp *scope.Scope, hctx *hsruntime.Context) (err error) {
+	h.client, h.limiter, h.retry = hctx.Client, hctx.RateLimiter, opts.Retry
+	h.acceptEncoding, h.compressBody = opts.AcceptEncoding, opts.CompressBody
+	if h.acceptEncoding == "" {
+		h.acceptEncoding = "gzip"
+	}
+	if h.compressBody != "" && !slices.Contains(RequestContentEncodings, h.compressBody) {
+		return fmt.Errorf("unsupported request content encoding '%s' (expected one of: %s)", h.compressBody, strings.Join(RequestContentEncodings, " "))
+	}
+	if err := h.auth.init(opts.Auth, scp, hctx); err != nil {
+		return err
+	}
+	if opts.SaveBody != "" {
+		if h.saveBody, err = parser.ParseTemplate(opts.SaveBody, scp, hctx.Funcs); err != nil {
+			return fmt.Errorf("bad save body path: %w", err)
+		}
+	}
+	return nil
+}
+
+// savePath returns the path to save the response body for input record in, or "" if not saving bodies.
+func (h *httpRunner) savePath(in record.Record, binds *scope.Bindings) (string, error) {
+	if h.saveBody == nil {
+		return "", nil
+	}
+	path, err := expr.EvalToString(h.saveBody, in, binds)
+	if err != nil {
+		return "", err
+	} else if path == "" {
+		return "", fmt.Errorf("empty save body path")
+	}
+	return path, nil
+}
+
 type HttpCommand struct {
 	httpBuilder
 	httpRunner
 }
 
-func NewHttpCommand(method, url, body string, headers []string, scope *scope.Scope, hctx *hsruntime.Context, retry RetryFunc) (cmd *HttpCommand, nextScope *scope.Scope, err error) {
-	cmd = &HttpCommand{
-		httpRunner: httpRunner{
-			client: hctx.Client,
-			retry:  retry,
-		},
+func NewHttpCommand(method, url, body string, headers []string, opts BuildOptions, runOpts RunOptions, scope *scope.Scope, hctx *hsruntime.Context) (cmd *HttpCommand, nextScope *scope.Scope, err error) {
+	cmd = &HttpCommand{}
+	if err := cmd.httpRunner.init(runOpts, scope, hctx); err != nil {
+		return nil, nil, err
 	}
 
-	cmd.httpBuilder, err = newHttpBuilder(method, url, body, headers, scope, hctx)
+	cmd.httpBuilder, err = newHttpBuilder(method, url, body, headers, opts, scope, hctx)
 	return cmd, scope, err
 }
 
@@ -76,28 +176,55 @@ func (h *HttpCommand) Run(ctx context.Context, in record.Record, binds *scope.Bi
 	if err != nil {
 		return nil, nil, err
 	}
+	savePath, err := h.savePath(in, binds)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds, err := h.auth.credentials(req, in, binds)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	out, err = h.httpRunner.run(ctx, req)
+	out, err = h.httpRunner.run(ctx, req, savePath, creds)
 	return out, binds, err
 }
 
-func newHttpBuilder(method, url, body string, headers []string, scp *scope.Scope, hctx *hsruntime.Context) (builder httpBuilder, finalErr error) {
+func newHttpBuilder(method, url, body string, headers []string, opts BuildOptions, scp *scope.Scope, hctx *hsruntime.Context) (builder httpBuilder, finalErr error) {
 	builder.method = method
+	builder.opts = opts
 	builder.hostAliasing = hctx.HostAliasing
+	builder.idemCounts = make(map[[sha256.Size]byte]int)
 
-	parse := func(src string, out *expr.Expr) bool {
+	// parse parses a template, escaping its interpolations according to escCtx (see escape.go)
+	parse := func(src string, escCtx expr.EscapeContext, out *expr.Expr) bool {
 		*out, finalErr = parser.ParseTemplate(src, scp, hctx.Funcs)
+		if finalErr == nil {
+			*out = expr.Escape(*out, escCtx)
+		}
 		return finalErr == nil
 	}
 
-	if !parse(url, &builder.url) {
+	if body != "" && len(opts.Form) > 0 {
+		finalErr = fmt.Errorf("cannot give both a body and form fields")
+		return
+	}
+
+	if !parse(url, urlEscapeContext, &builder.url) {
+		return
+	} else if body, isFile := cutFileRef(body); isFile && !parse(body, func(string) expr.Escaper { return nil }, &builder.bodyFile) {
 		return
-	} else if body != "" && !parse(body, &builder.body) {
+	} else if !isFile && body != "" && !parse(body, newBodyEscapeContext(body), &builder.body) {
 		return
 	}
+	builder.form = make([]formFieldTemplate, len(opts.Form))
+	for i, field := range opts.Form {
+		if builder.form[i], finalErr = parseFormField(field, scp, hctx); finalErr != nil {
+			return
+		}
+	}
 	builder.headers = make([]expr.Expr, len(headers))
 	for i, hdr := range headers {
-		if !parse(hdr, &builder.headers[i]) {
+		if !parse(hdr, headerEscapeContext, &builder.headers[i]) {
 			return
 		}
 	}
@@ -135,6 +262,15 @@ func (h *httpBuilder) buildRequest(in record.Record, binds *scope.Bindings) (req
 			return RequestAndBody{}, err
 		}
 		bodyStrToRequestBody(bodyStr, &req)
+	} else if h.bodyFile != nil {
+		path, err := expr.EvalToString(h.bodyFile, in, binds)
+		if err != nil {
+			return RequestAndBody{}, err
+		} else if path == "" {
+			return RequestAndBody{}, fmt.Errorf("empty request body file path")
+		} else if err := fileToRequestBody(path, &req); err != nil {
+			return RequestAndBody{}, err
+		}
 	}
 
 	for _, hdr := range h.headers {
@@ -151,21 +287,80 @@ func (h *httpBuilder) buildRequest(in record.Record, binds *scope.Bindings) (req
 		req.Header.Add(field, val)
 	}
 
+	if len(h.form) > 0 { // after headers, to use any Content-Type boundary given
+		fields := make([]FormField, len(h.form))
+		for i, f := range h.form {
+			if fields[i], err = f.eval(in, binds); err != nil {
+				return RequestAndBody{}, err
+			}
+		}
+		if err := formToRequestBody(fields, &req); err != nil {
+			return RequestAndBody{}, err
+		}
+	}
+
 	h.autodetectContentTypeIfNeeded(&req)
 
+	if h.opts.IdempotencyKey && req.Header.Get(idempotencyKeyHeader) == "" {
+		key, err := h.idempotencyKey(req, in, binds)
+		if err != nil {
+			return RequestAndBody{}, err
+		}
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+
 	return req, nil
 }
 
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyKey returns the idempotency key of req, built from input record in, as a UUID (version 8): a hash of its
+// method, URL, input record and source, and occurrence number among requests with the same.
+func (h *httpBuilder) idempotencyKey(req RequestAndBody, in record.Record, binds *scope.Bindings) (string, error) {
+	source := make(record.Array, len(h.opts.InputSource))
+	for i, id := range h.opts.InputSource {
+		source[i], _ = binds.Get(id)
+	}
+	data, err := json.Marshal(record.Array{req.Method, req.URL.String(), in, source}) // canonical: object keys are sorted
+	if err != nil {
+		return "", fmt.Errorf("idempotency key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	h.idemMtx.Lock()
+	h.idemCounts[sum]++
+	n := h.idemCounts[sum]
+	h.idemMtx.Unlock()
+
+	sum = sha256.Sum256(append(sum[:], strconv.Itoa(n)...))
+	b := sum[:16]
+	b[6] = b[6]&0x0f | 0x80 // version 8
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
 // autodetectContentTypeIfNeeded autodetects and (if successful) applies Content-Type to req, provided it has a body
 // and is missing Content-Type. It only detects on the first bodyContent seen, applying the same Content-Type thereafter.
+// If opts.BodyFormat is set, it is used instead of autodetecting. File bodies only get Content-Type from opts.BodyFormat.
 func (h *httpBuilder) autodetectContentTypeIfNeeded(req *RequestAndBody) {
-	if req.BodyContent == "" || len(req.Header.Values("Content-Type")) > 0 {
+	if len(req.Header.Values("Content-Type")) > 0 {
+		return
+	} else if req.BodyFile != "" {
+		if h.opts.BodyFormat != datafmt.Unknown {
+			req.Header.Add("Content-Type", h.opts.BodyFormat.ContentType())
+		}
+		return
+	} else if req.BodyContent == "" {
 		return
 	}
 
 	// Autodetect only once
 	h.autoContentTypeOnce.Do(func() {
-		h.autoContentType = datafmt.Autodetect(req.BodyContent).ContentType() // = "" if Unknown
+		if h.opts.BodyFormat != datafmt.Unknown {
+			h.autoContentType = h.opts.BodyFormat.ContentType()
+		} else {
+			h.autoContentType = datafmt.Autodetect(req.BodyContent).ContentType() // = "" if Unknown
+		}
 	})
 
 	if h.autoContentType != "" {
@@ -175,56 +370,103 @@ func (h *httpBuilder) autodetectContentTypeIfNeeded(req *RequestAndBody) {
 
 var dryrunErr = errors.New("request not sent")
 
-func (h *httpRunner) run(ctx context.Context, req RequestAndBody) (out record.Stream, err error) {
+// ErrDeadline, as (or wrapped by) the cause of the cancellation of a run's context, means the run's deadline passed.
+// Requests it cancels are recorded with "deadline":true (not "timeout"), and not retried.
+var ErrDeadline = errors.New("run deadline exceeded")
+
+// run sends req (with retries), returning the response record. If savePath is non-empty, a successful response body is
+// saved there; errors saving it (see ErrSaveBody) are returned. If creds is non-nil, req is authorized with them; they are not included in the record.
+func (h *httpRunner) run(ctx context.Context, req RequestAndBody, savePath string, creds *credentials) (out record.Stream, err error) {
 	req.Request = req.Request.WithContext(ctx)
 
-	req.Header.Set("Accept-Encoding", "gzip")
-	outReq := RequestAndBody{Request: req.Clone(ctx), BodyContent: req.BodyContent}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", h.acceptEncoding)
+	}
+	compressBody := h.compressBody != "" && req.GetBody != nil && req.ContentLength != 0 && req.Header.Get("Content-Encoding") == ""
+	outReq := req // as recorded: with the body as given, so not Content-Encoding
+	outReq.Request = req.Clone(ctx)
 
 	if h.dryrun.Load() {
 		outRec := requestResponseToRecord(outReq, ResponseAndBody{HTTPError: dryrunErr}, nil)
 		return &record.SingletonStream{Rec: outRec}, nil
 	}
 
+	if creds != nil {
+		creds.authorize(req)
+	}
+
+	// Binary body formats are represented as JSON text in records; encode for the wire
+	if f := datafmt.FromContentType(req.Header.Get("Content-Type")); f.Binary() && req.BodyContent != "" {
+		encBody, err := f.FromJSON(req.BodyContent)
+		if err != nil {
+			return nil, fmt.Errorf("request encode error: %w", err)
+		}
+		bodyStrToRequestBody(encBody, &req)
+	}
+	if compressBody {
+		if err := compressRequestBody(h.compressBody, &req); err != nil {
+			return nil, fmt.Errorf("request compress error: %w", err)
+		}
+		req.Header.Set("Content-Encoding", h.compressBody)
+	}
+
 	var resp ResponseAndBody
 	var retries []ResponseAndBody
 	for {
-		resp.Response, err = h.client.Do(req.Request)
-		if err != nil {
+		resp = ResponseAndBody{}
+		if resp.Response, err = h.send(req, creds); err != nil {
 			resp.HTTPError = err
 		} else {
-			var rdr io.Reader
-			if resp.Response.Header.Get("Content-Encoding") == "gzip" {
-				if gzipRdr, gzipRdrErr := gzip.NewReader(resp.Body); gzipRdrErr != nil {
+			rdr, decErr := decodeContentEncoding(resp.Header.Get("Content-Encoding"), resp.Body)
+			if decErr != nil {
+				_ = resp.Body.Close()
+				return nil, fmt.Errorf("response decode error: %w", decErr)
+			}
+
+			if savePath != "" && resp.StatusCode/100 == 2 {
+				if resp.SavedBody, err = saveBody(rdr, savePath); errors.Is(err, ErrSaveBody) {
 					_ = resp.Body.Close()
-					return nil, fmt.Errorf("gzip error: %w", gzipRdrErr)
-				} else {
-					rdr = gzipRdr
+					return nil, err // not a failed request, so not retried
 				}
+			} else if respBytes, readErr := io.ReadAll(rdr); readErr != nil {
+				err = fmt.Errorf("response read error: %w", readErr)
 			} else {
-				rdr = resp.Body
+				resp.BodyContent = string(respBytes)
+			}
+			_ = resp.Body.Close()
+			if err != nil { // e.g. connection lost or timed out; a failed request, as a connection error
+				resp = ResponseAndBody{HTTPError: err}
 			}
 
-			if respBytes, err := io.ReadAll(rdr); err != nil {
-				return nil, fmt.Errorf("response read error: %w", err)
-			} else {
-				_ = resp.Body.Close()
-				resp.BodyContent = string(respBytes)
+			// Binary body formats are represented as JSON text in records; decode from the wire
+			if resp.HTTPError == nil && resp.SavedBody == nil {
+				if f := datafmt.FromContentType(resp.Header.Get("Content-Type")); f.Binary() && resp.BodyContent != "" {
+					if jsonBody, decErr := f.ToJSON(resp.BodyContent); decErr != nil {
+						resp.BodyError = decErr // a failed request, with the body as received
+					} else {
+						resp.BodyContent = jsonBody
+					}
+				}
 			}
 		}
 
+		if cause := context.Cause(ctx); resp.HTTPError != nil && errors.Is(cause, ErrDeadline) {
+			resp.HTTPError = fmt.Errorf("%w: %v", cause, resp.HTTPError) // not wrapping the error, so not a timeout
+			break
+		}
+
 		if h.retry == nil {
 			break
-		} else if backoff, retry := h.retry(req, resp, len(retries)); !retry {
+		} else if backoff, retry, err := h.retry(outReq, resp, len(retries)); err != nil {
+			return nil, fmt.Errorf("retry error: %w", err)
+		} else if !retry {
 			break
 		} else {
-			time.Sleep(backoff)
-			if req.Body != nil { // rebuild Body if needed
-				if req.Body, err = req.GetBody(); err != nil {
-					return nil, fmt.Errorf("failed to retry request: %w", err)
-				}
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done(): // interrupted; give up retrying
+				return &record.SingletonStream{Rec: requestResponseToRecord(outReq, resp, retries)}, nil
 			}
-
 			retries = append(retries, resp)
 			continue
 		}
@@ -263,13 +505,23 @@ func requestToRecord(req RequestAndBody) record.Object {
 	}
 	if req.BodyContent != "" {
 		ret["body"] = req.BodyContent
+	} else if req.BodyFile != "" {
+		ret["body_file"] = req.BodyFile
+	} else if req.FormFields != nil {
+		ret["form"] = formToRecord(req.FormFields)
 	}
 	return ret
 }
 
 func responseToRecord(resp ResponseAndBody) record.Object {
 	if resp.HTTPError != nil {
-		return record.Object{"error": resp.HTTPError.Error()}
+		ret := record.Object{"error": resp.HTTPError.Error()}
+		if errors.Is(resp.HTTPError, ErrDeadline) {
+			ret["deadline"] = true
+		} else if isTimeout(resp.HTTPError) {
+			ret["timeout"] = true
+		}
+		return ret
 	}
 	ret := record.Object{
 		"status": float64(resp.StatusCode),
@@ -280,9 +532,23 @@ func responseToRecord(resp ResponseAndBody) record.Object {
 	if resp.BodyContent != "" {
 		ret["body"] = resp.BodyContent
 	}
+	if resp.BodyError != nil {
+		ret["body_error"] = resp.BodyError.Error()
+	}
+	if resp.SavedBody != nil {
+		ret["body_file"] = resp.SavedBody.Path
+		ret["body_bytes"] = float64(resp.SavedBody.Size)
+		ret["body_sha256"] = resp.SavedBody.SHA256
+	}
 	return ret
 }
 
+// isTimeout returns whether err is due to a timeout of a request attempt (or a phase thereof).
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
 func requestResponseToRecord(req RequestAndBody, resp ResponseAndBody, retries []ResponseAndBody) record.Object {
 	ret := requestToRecord(req)
 	ret["response"] = responseToRecord(resp)
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daboyuka/hs/hsruntime"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	scp, ids := scope.NewScope(nil, "input_file", "input_line")
+	opts := BuildOptions{IdempotencyKey: true, InputSource: ids}
+
+	// keys returns the idempotency keys of requests built (as by one run) from each record, at each source line
+	keys := func(recs []record.Record, lines []record.Record) (out []string) {
+		builder, err := newHttpBuilder("POST", "//example.com/${.id}", "", nil, opts, scp, hsruntime.NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, rec := range recs {
+			binds := scope.NewBindings(nil, map[scope.Ident]record.Record{ids[0]: "in.json", ids[1]: lines[i]})
+			req, err := builder.buildRequest(rec, binds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, req.Header.Get(idempotencyKeyHeader))
+		}
+		return out
+	}
+
+	a, b := record.Object{"id": "a"}, record.Object{"id": "b"}
+	recs, lines := []record.Record{a, b, a, a, a}, []record.Record{1.0, 2.0, 3.0, nil, nil}
+	run1, run2 := keys(recs, lines), keys(recs, lines)
+	for i := range run1 {
+		if run1[i] != run2[i] {
+			t.Errorf("record %d: expected same key in each run, got %s and %s", i, run1[i], run2[i])
+		}
+		for j := 0; j < i; j++ {
+			if run1[i] == run1[j] {
+				t.Errorf("records %d and %d: expected different keys, got %s", j, i, run1[i])
+			}
+		}
+	}
+
+	// Resuming (e.g. with --journal) skips earlier records; keys of records with known lines are unaffected
+	if resumed := keys(recs[2:3], lines[2:3]); resumed[0] != run1[2] {
+		t.Errorf("resumed record: expected key %s, got %s", run1[2], resumed[0])
+	}
+}
+
+func TestRunBinaryResponse(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Body       string
+		ExpectBody string
+		ExpectErr  bool
+	}{
+		{Name: "valid", Body: "\x81\xa1a\x01", ExpectBody: `{"a":1}`},
+		{Name: "invalid", Body: "\xc1", ExpectBody: "\xc1", ExpectErr: true},           // 0xc1 is never used
+		{Name: "truncated", Body: "\x82\x01", ExpectBody: "\x82\x01", ExpectErr: true}, // array of 2, with 1 element
+	}
+	for _, tst := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/msgpack")
+			_, _ = w.Write([]byte(tst.Body))
+		}))
+
+		h := &httpRunner{client: srv.Client(), acceptEncoding: "gzip"}
+		httpReq, _ := http.NewRequest("GET", srv.URL, nil)
+		out, err := h.run(context.Background(), RequestAndBody{Request: httpReq}, "", nil)
+		srv.Close()
+		if err != nil {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+			continue
+		}
+
+		// Undecodable bodies are recorded as received, with the decode error
+		rec, _ := out.Next()
+		resp := rec.(record.Object)["response"].(record.Object)
+		if resp["status"] != 200.0 || resp["body"] != tst.ExpectBody {
+			t.Errorf("test '%s': expected status 200 and body %q, got %v", tst.Name, tst.ExpectBody, resp)
+		}
+		if _, hasErr := resp["body_error"].(string); hasErr != tst.ExpectErr {
+			t.Errorf("test '%s': expected body_error %v, got %v", tst.Name, tst.ExpectErr, resp["body_error"])
+		}
+	}
+}
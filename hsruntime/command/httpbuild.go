@@ -12,9 +12,9 @@ type HttpBuildCommand struct {
 	httpBuilder
 }
 
-func NewHttpBuildCommand(method, url, body string, headers []string, scope *scope.Scope, hctx *hsruntime.Context) (cmd *HttpBuildCommand, nextScope *scope.Scope, err error) {
+func NewHttpBuildCommand(method, url, body string, headers []string, opts BuildOptions, scope *scope.Scope, hctx *hsruntime.Context) (cmd *HttpBuildCommand, nextScope *scope.Scope, err error) {
 	cmd = &HttpBuildCommand{}
-	cmd.httpBuilder, err = newHttpBuilder(method, url, body, headers, scope, hctx)
+	cmd.httpBuilder, err = newHttpBuilder(method, url, body, headers, opts, scope, hctx)
 	return cmd, scope, err
 }
 
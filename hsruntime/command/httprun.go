@@ -15,13 +15,12 @@ type HttpRunCommand struct {
 	httpRunner
 }
 
-func NewHttpRunCommand(scope *scope.Scope, hctx *hsruntime.Context, retry RetryFunc) (cmd *HttpRunCommand, nextScope *scope.Scope, finalErr error) {
-	return &HttpRunCommand{
-		httpRunner: httpRunner{
-			client: hctx.Client,
-			retry:  retry,
-		},
-	}, scope, nil
+func NewHttpRunCommand(runOpts RunOptions, scope *scope.Scope, hctx *hsruntime.Context) (cmd *HttpRunCommand, nextScope *scope.Scope, finalErr error) {
+	cmd = &HttpRunCommand{}
+	if err := cmd.httpRunner.init(runOpts, scope, hctx); err != nil {
+		return nil, nil, err
+	}
+	return cmd, scope, nil
 }
 
 func (h *HttpRunCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (out record.Stream, outBinds *scope.Bindings, err error) {
@@ -30,7 +29,16 @@ func (h *HttpRunCommand) Run(ctx context.Context, in record.Record, binds *scope
 		return nil, nil, err
 	}
 
-	out, err = h.run(ctx, req)
+	savePath, err := h.savePath(in, binds)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds, err := h.auth.credentials(req, in, binds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err = h.run(ctx, req, savePath, creds)
 	return out, binds, err
 }
 
@@ -71,6 +79,17 @@ func (h *HttpRunCommand) extractRequest(in record.Record) (req RequestAndBody, e
 
 	if bodyStr, ok := obj["body"].(string); ok {
 		bodyStrToRequestBody(bodyStr, &req)
+	} else if path, ok := obj["body_file"].(string); ok {
+		if err := fileToRequestBody(path, &req); err != nil {
+			return RequestAndBody{}, err
+		}
+	} else if formRec, ok := obj["form"]; ok {
+		fields, err := formFromRecord(formRec)
+		if err != nil {
+			return RequestAndBody{}, err
+		} else if err := formToRequestBody(fields, &req); err != nil {
+			return RequestAndBody{}, err
+		}
 	}
 
 	return req, nil
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daboyuka/hs/program/expr"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// RetryPolicy determines whether and when to retry requests. Connection errors, responses with a status in Statuses,
+// and (if If is set) responses for which If is truthy are retried, up to Max times. Requests with non-idempotent methods
+// (e.g. POST) are retried only if NonIdempotent is set or they have an Idempotency-Key header, since a request that
+// failed (e.g. timed out) may still have taken effect.
+type RetryPolicy struct {
+	Max        int           // max. retries per request
+	Delay      time.Duration // delay before the first retry
+	Backoff    float64       // factor by which the delay grows with each retry (1 = constant)
+	MaxDelay   time.Duration // if > 0, max. delay (including that requested by Retry-After)
+	Jitter     float64       // max. fraction of the delay to randomly add or subtract, in [0, 1]
+	Statuses   StatusSet     // response statuses to retry
+	RetryAfter bool          // if set, wait at least as long as requested by a Retry-After response header
+
+	NonIdempotent bool // if set, also retry requests with non-idempotent methods
+
+	If    expr.Expr       // if non-nil, also retry when truthy, evaluated on the output record (request and response)
+	Binds *scope.Bindings // bindings for evaluating If
+}
+
+// RetryFunc returns the RetryFunc implementing p, or nil if p permits no retries.
+func (p RetryPolicy) RetryFunc() RetryFunc {
+	if p.Max <= 0 {
+		return nil
+	}
+	return func(req RequestAndBody, resp ResponseAndBody, attempt int) (backoff time.Duration, retry bool, err error) {
+		if attempt >= p.Max || (!p.NonIdempotent && !isIdempotent(req)) {
+			return 0, false, nil
+		}
+
+		retry = resp.HTTPError != nil || p.Statuses.Contains(resp.StatusCode)
+		if !retry && p.If != nil {
+			v, err := p.If.Eval(requestResponseToRecord(req, resp, nil), p.Binds)
+			if err != nil {
+				return 0, false, err
+			}
+			retry = record.Truthy(v)
+		}
+		if !retry {
+			return 0, false, nil
+		}
+		return p.backoff(resp, attempt), true, nil
+	}
+}
+
+// backoff returns the delay before retry attempt after resp.
+func (p RetryPolicy) backoff(resp ResponseAndBody, attempt int) time.Duration {
+	d := float64(p.Delay) * math.Pow(max(p.Backoff, 1), float64(attempt))
+	if p.MaxDelay > 0 {
+		d = min(d, float64(p.MaxDelay))
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	backoff := time.Duration(d)
+
+	if p.RetryAfter && resp.Response != nil {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && after > backoff {
+			backoff = after
+		}
+	}
+	if p.MaxDelay > 0 {
+		backoff = min(backoff, p.MaxDelay)
+	}
+	return backoff
+}
+
+// isIdempotent returns whether req may be sent more than once with the same effect as sending it once: either its
+// method is idempotent, or it has an Idempotency-Key header (by which the server may deduplicate it).
+func isIdempotent(req RequestAndBody) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete: // "" means GET
+		return true
+	}
+	return req.Header.Get(idempotencyKeyHeader) != ""
+}
+
+// parseRetryAfter parses a Retry-After header value (delay seconds or HTTP-date), returning the delay from now.
+func parseRetryAfter(v string, now time.Time) (d time.Duration, ok bool) {
+	if v == "" {
+		return 0, false
+	} else if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	} else if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// StatusSet is a set of HTTP status codes, as a list of inclusive ranges.
+type StatusSet [][2]int
+
+// ParseStatusSet parses a comma-separated list of status codes (e.g. "429"), ranges (e.g. "500-504") or classes (e.g.
+// "5xx"). The empty string is the empty set.
+func ParseStatusSet(src string) (set StatusSet, err error) {
+	if src == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(src, ",") {
+		item = strings.TrimSpace(item)
+		var lo, hi int
+		if class, ok := strings.CutSuffix(strings.ToLower(item), "xx"); ok && len(class) == 1 {
+			lo, err = strconv.Atoi(class)
+			lo, hi = lo*100, lo*100+99
+		} else if loStr, hiStr, ok := strings.Cut(item, "-"); ok {
+			if lo, err = strconv.Atoi(loStr); err == nil {
+				hi, err = strconv.Atoi(hiStr)
+			}
+		} else {
+			lo, err = strconv.Atoi(item)
+			hi = lo
+		}
+		if err != nil || lo < 100 || hi > 599 || lo > hi {
+			return nil, fmt.Errorf("bad status code, range or class '%s'", item)
+		}
+		set = append(set, [2]int{lo, hi})
+	}
+	return set, nil
+}
+
+func (s StatusSet) Contains(status int) bool {
+	for _, r := range s {
+		if r[0] <= status && status <= r[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package command
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+func TestParseStatusSet(t *testing.T) {
+	tests := []struct {
+		Src     string
+		In, Out []int
+		WantErr bool
+	}{
+		{Src: "5xx", In: []int{500, 503, 599}, Out: []int{200, 429, 600}},
+		{Src: "429, 500-504", In: []int{429, 500, 504}, Out: []int{428, 505}},
+		{Src: "", Out: []int{200, 500}},
+		{Src: "5x", WantErr: true},
+		{Src: "504-500", WantErr: true},
+		{Src: "42", WantErr: true},
+		{Src: "0xx", WantErr: true},
+	}
+	for _, tst := range tests {
+		set, err := ParseStatusSet(tst.Src)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%s': unexpected error: %v", tst.Src, err)
+			continue
+		}
+		for _, status := range tst.In {
+			if !set.Contains(status) {
+				t.Errorf("test '%s': expected %d in set", tst.Src, status)
+			}
+		}
+		for _, status := range tst.Out {
+			if set.Contains(status) {
+				t.Errorf("test '%s': expected %d not in set", tst.Src, status)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	statuses, _ := ParseStatusSet("429,5xx")
+	p := RetryPolicy{Max: 4, Delay: time.Second, Backoff: 2, MaxDelay: 5 * time.Second, Statuses: statuses, RetryAfter: true}
+	retry := p.RetryFunc()
+
+	resp := func(status int, retryAfter string) ResponseAndBody {
+		r := &http.Response{StatusCode: status, Header: make(http.Header)}
+		if retryAfter != "" {
+			r.Header.Set("Retry-After", retryAfter)
+		}
+		return ResponseAndBody{Response: r}
+	}
+
+	tests := []struct {
+		Name    string
+		Resp    ResponseAndBody
+		Attempt int
+		Retry   bool
+		Backoff time.Duration
+	}{
+		{Name: "success", Resp: resp(200, ""), Retry: false},
+		{Name: "client error", Resp: resp(404, ""), Retry: false},
+		{Name: "first retry", Resp: resp(503, ""), Retry: true, Backoff: time.Second},
+		{Name: "exponential", Resp: resp(503, ""), Attempt: 2, Retry: true, Backoff: 4 * time.Second},
+		{Name: "max delay", Resp: resp(503, ""), Attempt: 3, Retry: true, Backoff: 5 * time.Second},
+		{Name: "max retries", Resp: resp(503, ""), Attempt: 4, Retry: false},
+		{Name: "conn error", Resp: ResponseAndBody{HTTPError: http.ErrHandlerTimeout}, Retry: true, Backoff: time.Second},
+		{Name: "Retry-After", Resp: resp(429, "3"), Retry: true, Backoff: 3 * time.Second},
+		{Name: "Retry-After shorter", Resp: resp(429, "0"), Attempt: 1, Retry: true, Backoff: 2 * time.Second},
+		{Name: "Retry-After capped", Resp: resp(429, "3600"), Retry: true, Backoff: 5 * time.Second},
+		{Name: "Retry-After bad", Resp: resp(429, "soon"), Retry: true, Backoff: time.Second},
+	}
+	for _, tst := range tests {
+		backoff, ok, err := retry(RequestAndBody{Request: &http.Request{Method: http.MethodGet}}, tst.Resp, tst.Attempt)
+		if err != nil {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+		} else if ok != tst.Retry || (ok && backoff != tst.Backoff) {
+			t.Errorf("test '%s': got retry=%v backoff=%v, expect retry=%v backoff=%v", tst.Name, ok, backoff, tst.Retry, tst.Backoff)
+		}
+	}
+}
+
+func TestRetryPolicyNonIdempotent(t *testing.T) {
+	statuses, _ := ParseStatusSet("5xx")
+	resp := ResponseAndBody{Response: &http.Response{StatusCode: 503}}
+	req := func(method, idemKey string) RequestAndBody {
+		r := &http.Request{Method: method, Header: make(http.Header)}
+		if idemKey != "" {
+			r.Header.Set("Idempotency-Key", idemKey)
+		}
+		return RequestAndBody{Request: r}
+	}
+
+	tests := []struct {
+		Name          string
+		Req           RequestAndBody
+		NonIdempotent bool
+		Retry         bool
+	}{
+		{Name: "PUT", Req: req(http.MethodPut, ""), Retry: true},
+		{Name: "POST", Req: req(http.MethodPost, ""), Retry: false},
+		{Name: "PATCH", Req: req(http.MethodPatch, ""), Retry: false},
+		{Name: "POST with key", Req: req(http.MethodPost, "abc"), Retry: true},
+		{Name: "POST opted in", Req: req(http.MethodPost, ""), NonIdempotent: true, Retry: true},
+	}
+	for _, tst := range tests {
+		retry := RetryPolicy{Max: 1, Statuses: statuses, NonIdempotent: tst.NonIdempotent}.RetryFunc()
+		if _, ok, _ := retry(tst.Req, resp, 0); ok != tst.Retry {
+			t.Errorf("test '%s': got retry=%v, expect %v", tst.Name, ok, tst.Retry)
+		}
+	}
+}
+
+func TestRetryPolicyIf(t *testing.T) {
+	cond, err := parser.ParseExpr(`.response.headers["X-Retry"]`, nil, scope.NewFuncTable(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq, _ := http.NewRequest("GET", "http://example.com", nil)
+	req := RequestAndBody{Request: httpReq}
+	resp := func(status int, retryHdr string) ResponseAndBody {
+		hdr := http.Header{"Date": {"x"}}
+		if retryHdr != "" {
+			hdr.Set("X-Retry", retryHdr)
+		}
+		return ResponseAndBody{Response: &http.Response{StatusCode: status, Header: hdr}}
+	}
+
+	tests := []struct {
+		Name  string
+		Resp  ResponseAndBody
+		Retry bool
+	}{
+		{Name: "condition true", Resp: resp(200, "yes"), Retry: true},
+		{Name: "condition null", Resp: resp(200, ""), Retry: false},
+		{Name: "status", Resp: resp(503, ""), Retry: true},
+	}
+	statuses, _ := ParseStatusSet("5xx")
+	retry := RetryPolicy{Max: 1, Statuses: statuses, If: cond}.RetryFunc()
+	for _, tst := range tests {
+		if _, ok, err := retry(req, tst.Resp, 0); err != nil {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+		} else if ok != tst.Retry {
+			t.Errorf("test '%s': got retry=%v, expect %v", tst.Name, ok, tst.Retry)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d, ok := parseRetryAfter("Mon, 01 Jan 2024 00:00:10 GMT", now); !ok || d != 10*time.Second {
+		t.Errorf("HTTP-date: got %v, %v", d, ok)
+	}
+	if d, ok := parseRetryAfter("Sun, 31 Dec 2023 00:00:00 GMT", now); !ok || d != 0 {
+		t.Errorf("past HTTP-date: got %v, %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("", now); ok {
+		t.Errorf("empty: expected not ok")
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // never responds
+	}))
+	defer srv.Close()
+
+	var retries int
+	h := &httpRunner{client: srv.Client(), acceptEncoding: "gzip", retry: func(RequestAndBody, ResponseAndBody, int) (time.Duration, bool, error) {
+		retries++
+		return 0, true, nil
+	}}
+	httpReq, _ := http.NewRequest("GET", srv.URL, nil)
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 50*time.Millisecond, ErrDeadline)
+	defer cancel()
+	out, err := h.run(ctx, RequestAndBody{Request: httpReq}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, _ := out.Next()
+	resp := rec.(record.Object)["response"].(record.Object)
+	if resp["deadline"] != true || resp["timeout"] != nil || retries != 0 {
+		t.Errorf("expected deadline error, not a timeout, and no retries; got %v (%d retries)", resp, retries)
+	}
+}
@@ -0,0 +1,72 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SavedBody describes a response body saved to a file.
+type SavedBody struct {
+	Path   string
+	Size   int64
+	SHA256 string // hex-encoded
+}
+
+// ErrSaveBody wraps errors saving a response body locally (e.g. creating directories or writing the file), as opposed to
+// errors reading it. These are run errors, rather than failed requests, so are not retried.
+var ErrSaveBody = errors.New("response save error")
+
+// saveBody streams r to a file at path, creating parent directories as needed. The file is written in full under a
+// temporary name, then renamed, so path never holds a partial body (e.g. if the connection is lost). Errors saving the
+// file wrap ErrSaveBody; errors reading r do not.
+func saveBody(r io.Reader, path string) (*SavedBody, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSaveBody, err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSaveBody, err)
+	}
+	defer os.Remove(f.Name()) // no effect once renamed
+
+	if err := f.Chmod(0644); err != nil { // CreateTemp uses 0600
+		_ = f.Close()
+		return nil, fmt.Errorf("%w: %w", ErrSaveBody, err)
+	}
+
+	h := sha256.New()
+	rr := &readErrReader{r: r}
+	n, err := io.Copy(io.MultiWriter(f, h), rr)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if rr.err != nil {
+		return nil, fmt.Errorf("response read error: %w", rr.err)
+	} else if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSaveBody, err)
+	}
+	return &SavedBody{Path: path, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// readErrReader records the error (other than io.EOF) of reading r, to tell it apart from errors writing what was read.
+type readErrReader struct {
+	r   io.Reader
+	err error
+}
+
+func (rr *readErrReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if err != nil && err != io.EOF {
+		rr.err = err
+	}
+	return n, err
+}
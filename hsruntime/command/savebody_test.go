@@ -0,0 +1,71 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveBody(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "out.bin")
+
+	saved, err := saveBody(strings.NewReader("hello"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := SavedBody{Path: path, Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+	if *saved != expect {
+		t.Errorf("expected %+v, got %+v", expect, *saved)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "hello" {
+		t.Errorf("expected file content 'hello', got '%s' (err %v)", data, err)
+	}
+
+	// A failed read leaves no file behind
+	failPath := filepath.Join(dir, "fail.bin")
+	if _, err := saveBody(io.MultiReader(strings.NewReader("partial"), errReader{}), failPath); err == nil || errors.Is(err, ErrSaveBody) {
+		t.Errorf("expected read error, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 { // just "sub"
+		t.Errorf("expected no files left after failed save, got %d entries", len(entries))
+	}
+
+	// A failure to save locally is an ErrSaveBody
+	if _, err := saveBody(strings.NewReader("hello"), filepath.Join(path, "under-a-file.bin")); !errors.Is(err, ErrSaveBody) {
+		t.Errorf("expected save error, got %v", err)
+	}
+}
+
+func TestRunSaveBodyError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	var retries int
+	h := &httpRunner{client: srv.Client(), acceptEncoding: "gzip", retry: func(RequestAndBody, ResponseAndBody, int) (time.Duration, bool, error) {
+		retries++
+		return 0, retries < 3, nil
+	}}
+	httpReq, _ := http.NewRequest("GET", srv.URL, nil)
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.run(context.Background(), RequestAndBody{Request: httpReq}, filepath.Join(file, "out.bin"), nil); !errors.Is(err, ErrSaveBody) || retries != 0 {
+		t.Errorf("expected save error, and no retries; got %v (%d retries)", err, retries)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection lost") }
@@ -0,0 +1,66 @@
+package datafmt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b, 0x08} // with compression method deflate, the only one defined
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// DecompressReader sniffs the beginning of r for gzip, zstd or xz compression, returning a reader of the decompressed
+// data if found, or of the original data otherwise. It reads from r only as far as needed to rule out compression, so
+// doesn't wait for more of uncompressed input (e.g. streamed lines) than its first read returns.
+func DecompressReader(r io.Reader) (r2 io.Reader, err error) {
+	br := bufio.NewReader(r)
+	magic, err := peekMagic(br)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		r2, err = gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(br, zstd.WithDecoderConcurrency(1)) // decodes synchronously, so needn't be closed
+		r2 = d
+	case bytes.HasPrefix(magic, xzMagic):
+		r2, err = xz.NewReader(br)
+	default:
+		return br, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompression error: %w", err)
+	}
+	return r2, nil
+}
+
+// peekMagic peeks the beginning of br, until it is not a proper prefix of any compression magic.
+func peekMagic(br *bufio.Reader) ([]byte, error) {
+	for n := 1; ; n++ {
+		b, err := br.Peek(n)
+		if err != nil || !isMagicPrefix(b) {
+			return b, err
+		}
+	}
+}
+
+func isMagicPrefix(b []byte) bool {
+	for _, magic := range [][]byte{gzipMagic, zstdMagic, xzMagic} {
+		if len(b) < len(magic) && bytes.HasPrefix(magic, b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package datafmt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// msgpackGzipID is MessagePack beginning with the gzip ID bytes (0x1f 0x8b): 31, then a map of 11 entries ("a":0 to "k":10).
+var msgpackGzipID = func() []byte {
+	b := []byte{0x1f, 0x8b}
+	for i := 0; i < 11; i++ {
+		b = append(b, 0xa1, byte('a'+i), byte(i))
+	}
+	return b
+}()
+
+func TestDecompressReader(t *testing.T) {
+	const data = "{\"a\":1}\n{\"a\":2}\n"
+
+	compress := func(newWriter func(w io.Writer) (io.WriteCloser, error)) []byte {
+		var buf bytes.Buffer
+		w, err := newWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.WriteString(w, data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		Name   string
+		Input  []byte
+		Expect string
+	}{
+		{Name: "uncompressed", Input: []byte(data), Expect: data},
+		{Name: "uncompressed, shorter than magic", Input: []byte("x"), Expect: "x"},
+		{Name: "uncompressed, gzip ID prefix", Input: msgpackGzipID, Expect: string(msgpackGzipID)},
+		{Name: "gzip", Input: compress(func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }), Expect: data},
+		{Name: "zstd", Input: compress(func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }), Expect: data},
+		{Name: "xz", Input: compress(func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }), Expect: data},
+	}
+
+	for _, tst := range tests {
+		r, err := DecompressReader(bytes.NewReader(tst.Input))
+		if err != nil {
+			t.Errorf("test '%s' failed: unexpected error: %s", tst.Name, err)
+			continue
+		}
+		if got, err := io.ReadAll(r); err != nil {
+			t.Errorf("test '%s' failed: unexpected read error: %s", tst.Name, err)
+		} else if string(got) != tst.Expect {
+			t.Errorf("test '%s' failed: got %q, expect %q", tst.Name, got, tst.Expect)
+		}
+	}
+}
+
+func TestDecompressReaderStreaming(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() { _, _ = pw.Write([]byte("a\n")) }() // and no more, for now
+
+	done := make(chan string)
+	go func() {
+		r, err := DecompressReader(pr)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			close(done)
+			return
+		}
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		done <- line
+	}()
+
+	select {
+	case line := <-done:
+		if line != "a\n" {
+			t.Errorf("got %q, expect %q", line, "a\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("blocked waiting for more input")
+	}
+}
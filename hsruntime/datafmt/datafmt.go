@@ -2,9 +2,13 @@ package datafmt
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"mime"
 	"net/url"
 	"strings"
+
+	"github.com/daboyuka/hs/program/record"
 )
 
 //go:generate stringer -type=Format
@@ -14,16 +18,96 @@ const (
 	Unknown = Format(iota)
 	JSON
 	FormData
+	MsgPack
+	CBOR
 )
 
 var contentType = [...]string{
 	Unknown:  "",
 	JSON:     "application/json",
 	FormData: "application/x-www-form-urlencoded",
+	MsgPack:  "application/msgpack",
+	CBOR:     "application/cbor",
+}
+
+// contentTypeAliases are additional media types recognized by FromContentType.
+var contentTypeAliases = map[string]Format{
+	"application/x-msgpack":   MsgPack,
+	"application/vnd.msgpack": MsgPack,
 }
 
 func (f Format) ContentType() string { return contentType[f] }
 
+// contentTypeSuffixes are structured syntax suffixes (RFC 6839) of media types recognized by FromContentType, e.g.
+// application/problem+json.
+var contentTypeSuffixes = map[string]Format{
+	"+json":    JSON,
+	"+msgpack": MsgPack,
+	"+cbor":    CBOR,
+}
+
+// FromContentType returns the Format for a Content-Type header value (parameters are ignored), or Unknown.
+func FromContentType(ct string) Format {
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return Unknown
+	} else if f, ok := contentTypeAliases[mediaType]; ok {
+		return f
+	}
+	for f, fct := range contentType {
+		if fct != "" && fct == mediaType {
+			return Format(f)
+		}
+	}
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		return contentTypeSuffixes[mediaType[i:]] // Unknown if not found
+	}
+	return Unknown
+}
+
+// Binary returns true if f is a binary encoding of JSON-like data. Since hs handles bodies as text, request and
+// response bodies in these formats are represented as JSON text, and converted with FromJSON and ToJSON.
+func (f Format) Binary() bool { return f == MsgPack || f == CBOR }
+
+// FromJSON converts JSON text to binary format f.
+func (f Format) FromJSON(data string) (string, error) {
+	var rec record.Record
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return "", fmt.Errorf("%s body is not valid JSON: %w", f, err)
+	}
+
+	var out []byte
+	var err error
+	switch f {
+	case MsgPack:
+		out, err = record.MarshalMsgpack(rec)
+	case CBOR:
+		out, err = record.MarshalCBOR(rec)
+	default:
+		return "", fmt.Errorf("cannot convert JSON to %s", f)
+	}
+	return string(out), err
+}
+
+// ToJSON converts data in binary format f to JSON text.
+func (f Format) ToJSON(data string) (string, error) {
+	var rec record.Record
+	var err error
+	switch f {
+	case MsgPack:
+		rec, err = record.UnmarshalMsgpack([]byte(data))
+	case CBOR:
+		rec, err = record.UnmarshalCBOR([]byte(data))
+	default:
+		return "", fmt.Errorf("cannot convert %s to JSON", f)
+	}
+	if err != nil {
+		return "", fmt.Errorf("bad %s body: %w", f, err)
+	}
+	out, err := json.Marshal(rec)
+	return string(out), err
+}
+
 const maxAutodetectLen = 512
 
 func Autodetect(data string) (format Format) {
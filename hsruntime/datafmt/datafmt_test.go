@@ -31,3 +31,63 @@ func TestAutodetect(t *testing.T) {
 		}
 	}
 }
+
+func TestFromContentType(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Expect Format
+	}{
+		{Input: "application/json; charset=utf-8", Expect: JSON},
+		{Input: "application/x-www-form-urlencoded", Expect: FormData},
+		{Input: "application/msgpack", Expect: MsgPack},
+		{Input: "application/x-msgpack", Expect: MsgPack},
+		{Input: "Application/CBOR", Expect: CBOR},
+		{Input: "application/problem+json", Expect: JSON},
+		{Input: "application/vnd.example.event+msgpack", Expect: MsgPack},
+		{Input: "application/senml+cbor; charset=x", Expect: CBOR},
+		{Input: "application/vnd.example+xml", Expect: Unknown},
+		{Input: "text/plain", Expect: Unknown},
+		{Input: "", Expect: Unknown},
+	}
+	for _, tst := range tests {
+		if got := FromContentType(tst.Input); got != tst.Expect {
+			t.Errorf("test '%s' failed: got %s, expect %s", tst.Input, got, tst.Expect)
+		}
+	}
+}
+
+func TestBinaryJSON(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Input string
+	}{
+		{Name: "object", Input: `{"a":[1,-2.5,"x",true,null],"b":{"c":1e+21}}`},
+		{Name: "array", Input: `[{},[],""]`},
+		{Name: "string", Input: `"hello"`},
+		{Name: "integer", Input: `4294967296`},
+	}
+	for _, f := range []Format{MsgPack, CBOR} {
+		for _, tst := range tests {
+			bin, err := f.FromJSON(tst.Input)
+			if err != nil {
+				t.Errorf("test '%s' (%s): unexpected error: %v", tst.Name, f, err)
+				continue
+			}
+			if got, err := f.ToJSON(bin); err != nil {
+				t.Errorf("test '%s' (%s): unexpected error: %v", tst.Name, f, err)
+			} else if got != tst.Input {
+				t.Errorf("test '%s' (%s) failed: got %s, expect %s", tst.Name, f, got, tst.Input)
+			}
+		}
+
+		if _, err := f.FromJSON(`{"a":`); err == nil {
+			t.Errorf("%s: expected error converting invalid JSON", f)
+		}
+		if _, err := f.ToJSON("\xc1"); err == nil { // reserved in msgpack; a truncated item in CBOR
+			t.Errorf("%s: expected error converting invalid data", f)
+		}
+	}
+	if _, err := JSON.FromJSON(`{}`); err == nil {
+		t.Errorf("expected error converting to non-binary format")
+	}
+}
@@ -11,11 +11,13 @@ func _() {
 	_ = x[Unknown-0]
 	_ = x[JSON-1]
 	_ = x[FormData-2]
+	_ = x[MsgPack-3]
+	_ = x[CBOR-4]
 }
 
-const _Format_name = "UnknownJSONFormData"
+const _Format_name = "UnknownJSONFormDataMsgPackCBOR"
 
-var _Format_index = [...]uint8{0, 7, 11, 19}
+var _Format_index = [...]uint8{0, 7, 11, 19, 26, 30}
 
 func (i Format) String() string {
 	if i < 0 || i >= Format(len(_Format_index)-1) {
@@ -0,0 +1,25 @@
+package hsruntime
+
+import (
+	"fmt"
+
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// builtinFuncs are the funcs available in every Context created by NewContext.
+var builtinFuncs = map[string]scope.Func{
+	"fromxml": fromXML,
+}
+
+// fromXML parses its string argument as an XML document, returning the root element as an object (see record.ParseXML).
+func fromXML(args ...record.Record) (record.Record, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 1 arg, got %d", len(args))
+	}
+	doc, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected string, got %T", args[0])
+	}
+	return record.ParseXML(doc)
+}
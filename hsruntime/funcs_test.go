@@ -0,0 +1,40 @@
+package hsruntime
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/daboyuka/hs/program/expr/parser"
+	"github.com/daboyuka/hs/program/record"
+)
+
+func TestFromXML(t *testing.T) {
+	hctx := NewContext()
+	e, err := parser.ParseExpr(`(fromxml .body)`, hctx.Globals.Scope, hctx.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name    string
+		Input   record.Record
+		Expect  record.Record
+		WantErr bool
+	}{
+		{
+			Name:   "document",
+			Input:  `<r a="1"><x>1</x><x>2</x>text</r>`,
+			Expect: record.Object{"@": record.Object{"a": "1"}, "x": record.Array{"1", "2"}, "#text": "text"},
+		},
+		{Name: "malformed", Input: `<r><x></r>`, WantErr: true},
+		{Name: "not a string", Input: 1.0, WantErr: true},
+	}
+	for _, tst := range tests {
+		got, err := e.Eval(record.Object{"body": tst.Input}, hctx.Globals.Binds)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+		} else if !tst.WantErr && !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': got %v, expect %v", tst.Name, got, tst.Expect)
+		}
+	}
+}
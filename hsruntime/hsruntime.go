@@ -6,10 +6,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/daboyuka/hs/hsruntime/config"
 	"github.com/daboyuka/hs/hsruntime/cookie"
 	"github.com/daboyuka/hs/hsruntime/hostalias"
+	"github.com/daboyuka/hs/hsruntime/ratelimit"
 	"github.com/daboyuka/hs/program/scope"
 )
 
@@ -19,6 +21,8 @@ type Context struct {
 
 	ConfigInit   ConfigInitFn
 	HostAliasing hostalias.HostAlias
+	Auth         map[string]string  // host (as hostname or hostname:port, lowercase) -> auth spec; from config AUTH
+	RateLimiter  *ratelimit.Limiter // nil if unlimited
 	Client       *http.Client
 }
 
@@ -27,6 +31,7 @@ type ConfigInitFn func(cfg string) (string, error)
 
 func NewContext() *Context {
 	return &Context{
+		Funcs:        scope.NewFuncTable(nil, builtinFuncs),
 		ConfigInit:   config.DefaultConfiguration,
 		HostAliasing: hostalias.None,
 		Client:       &http.Client{},
@@ -35,6 +40,19 @@ func NewContext() *Context {
 
 type Options struct {
 	CookieSpecs []string
+	Rate        string // global request rate limit (see ratelimit.ParseRate), or "" if none
+	Timeouts    Timeouts
+	Transport   TransportOptions
+	TLS         TLSOptions
+	Proxy       ProxyOptions
+}
+
+// Timeouts limit the phases of each request attempt. Zero means no limit.
+type Timeouts struct {
+	Request        time.Duration // entire attempt, including reading the response body
+	Connect        time.Duration // establishing a connection
+	TLSHandshake   time.Duration // TLS handshake, once connected
+	ResponseHeader time.Duration // awaiting response headers, once the request is written
 }
 
 // NewDefaultContext returns a default setup of Context, binding standard funcs, loading config, etc.
@@ -49,6 +67,31 @@ func NewDefaultContext(opts Options) (ctx *Context, err error) {
 		return nil, err
 	}
 
+	if ctx.Auth, err = defaultAuth(ctx.HostAliasing, ctx.Globals); err != nil {
+		return nil, err
+	}
+
+	transportOpts, err := defaultTransportOptions(opts.Transport, ctx.Globals)
+	if err != nil {
+		return nil, err
+	}
+	tlsOpts, err := defaultTLSOptions(opts.TLS, ctx.Globals)
+	if err != nil {
+		return nil, err
+	}
+	proxyOpts, err := defaultProxyOptions(opts.Proxy, ctx.Globals)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Client.Transport, err = newRoundTripper(opts.Timeouts, transportOpts, tlsOpts, proxyOpts); err != nil {
+		return nil, err
+	}
+	ctx.Client.Timeout = opts.Timeouts.Request
+
+	if ctx.RateLimiter, err = defaultRateLimiter(opts.Rate, ctx.Globals); err != nil {
+		return nil, err
+	}
+
 	ctx.Client.Jar = cookie.DeferredJarLoader(func() (http.CookieJar, error) {
 		return cookie.Load(opts.CookieSpecs, ctx.Globals)
 	})
@@ -126,3 +169,58 @@ func defaultHostAliasing(base hostalias.HostAlias, globals scope.ScopedBindings)
 		return hostalias.Compose(base, hostalias.Simple(aliasesStr)), nil
 	}
 }
+
+// defaultAuth returns auth specs by host from config AUTH, if bound, whose keys are host aliases (as in HOST_ALIASES, with
+// or without the leading @), resolved per hostAliasing, or else hosts.
+func defaultAuth(hostAliasing hostalias.HostAlias, globals scope.ScopedBindings) (map[string]string, error) {
+	authIntf, _ := globals.Lookup("AUTH")
+
+	switch auth := authIntf.(type) {
+	default:
+		return nil, fmt.Errorf("expected map for AUTH, got %T", auth)
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		hostAuth := make(map[string]string, len(auth))
+		for k, v := range auth {
+			vStr, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string values for AUTH mappings, got %T", v)
+			}
+			host := strings.TrimPrefix(k, "@")
+			if aliased := hostAliasing(host); aliased != "" {
+				host = aliased
+			} else if strings.HasPrefix(k, "@") {
+				return nil, fmt.Errorf("unknown host alias %s in AUTH", k)
+			}
+			hostAuth[strings.ToLower(host)] = vStr
+		}
+		return hostAuth, nil
+	}
+}
+
+func defaultRateLimiter(global string, globals scope.ScopedBindings) (*ratelimit.Limiter, error) {
+	limitsIntf, _ := globals.Lookup("RATE_LIMITS")
+
+	var hostLimits map[string]string
+	switch limits := limitsIntf.(type) {
+	default:
+		return nil, fmt.Errorf("expected map for RATE_LIMITS, got %T", limits)
+	case nil:
+	case map[string]interface{}:
+		hostLimits = make(map[string]string, len(limits))
+		for k, v := range limits {
+			vStr, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string values for RATE_LIMITS mappings, got %T", v)
+			}
+			hostLimits[k] = vStr
+		}
+	}
+
+	limiter, err := ratelimit.New(global, hostLimits)
+	if err != nil {
+		return nil, fmt.Errorf("bad rate limit: %w", err)
+	}
+	return limiter, nil
+}
@@ -0,0 +1,141 @@
+package hsruntime
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// ProxyDirect, as a proxy URL, means to connect directly, without a proxy.
+const ProxyDirect = "direct"
+
+// ProxyOptions select the proxy for each request, by the request's host: a host matching NoProxy connects directly;
+// otherwise, Proxy is used if set; otherwise, the most specific match in Hosts; otherwise, the proxy given by the
+// environment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY), if any.
+//
+// Proxy URLs may have scheme http, https, socks5 or socks5h (default http), and userinfo for proxy authentication.
+type ProxyOptions struct {
+	Proxy   string            // if non-empty, the proxy URL for all hosts (or ProxyDirect)
+	NoProxy []string          // host globs (as path.Match, e.g. "*.internal") to connect to directly; "*" for all
+	Hosts   map[string]string // host glob -> proxy URL (or ProxyDirect); set from config PROXIES
+}
+
+// defaultProxyOptions returns opts, with Hosts added from config PROXIES, if bound.
+func defaultProxyOptions(opts ProxyOptions, globals scope.ScopedBindings) (ProxyOptions, error) {
+	proxiesIntf, _ := globals.Lookup("PROXIES")
+
+	switch proxies := proxiesIntf.(type) {
+	default:
+		return opts, fmt.Errorf("expected map for PROXIES, got %T", proxies)
+	case nil:
+		return opts, nil
+	case map[string]interface{}:
+		hosts := make(map[string]string, len(opts.Hosts)+len(proxies))
+		for k, v := range proxies {
+			vStr, ok := v.(string)
+			if !ok {
+				return opts, fmt.Errorf("expected string values for PROXIES mappings, got %T", v)
+			}
+			hosts[k] = vStr
+		}
+		for k, v := range opts.Hosts { // given options take precedence
+			hosts[k] = v
+		}
+		opts.Hosts = hosts
+		return opts, nil
+	}
+}
+
+// hostProxy is a parsed Hosts entry.
+type hostProxy struct {
+	host  string
+	proxy *url.URL // nil if direct
+}
+
+// newProxyFunc returns a func selecting the proxy for a request per opts, as http.Transport.Proxy.
+func newProxyFunc(opts ProxyOptions) (func(*http.Request) (*url.URL, error), error) {
+	for _, glob := range opts.NoProxy {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("bad no-proxy host '%s': %w", glob, err)
+		}
+	}
+
+	var proxy *url.URL
+	if opts.Proxy != "" {
+		var err error
+		if proxy, err = parseProxyURL(opts.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	hosts := make([]hostProxy, 0, len(opts.Hosts))
+	for host, proxySrc := range opts.Hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("bad proxy host '%s': %w", host, err)
+		}
+		u, err := parseProxyURL(proxySrc)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy for host '%s': %w", host, err)
+		}
+		hosts = append(hosts, hostProxy{host: host, proxy: u})
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host, hostPort := req.URL.Hostname(), req.URL.Host
+		matches := func(glob string) bool { return matchHost(glob, host) || matchHost(glob, hostPort) }
+
+		for _, glob := range opts.NoProxy {
+			if matches(glob) {
+				return nil, nil
+			}
+		}
+		if opts.Proxy != "" {
+			return proxy, nil
+		}
+
+		// Most specific match: an exact host, else the longest matching glob
+		var best *hostProxy
+		for i, hp := range hosts {
+			if !matches(hp.host) {
+				continue
+			}
+			exact := strings.EqualFold(hp.host, host) || strings.EqualFold(hp.host, hostPort)
+			if exact {
+				return hp.proxy, nil
+			} else if best == nil || len(hp.host) > len(best.host) || (len(hp.host) == len(best.host) && hp.host < best.host) {
+				best = &hosts[i]
+			}
+		}
+		if best != nil {
+			return best.proxy, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}, nil
+}
+
+// parseProxyURL parses a proxy URL, returning nil for ProxyDirect.
+func parseProxyURL(src string) (*url.URL, error) {
+	if src == ProxyDirect {
+		return nil, nil
+	} else if !strings.Contains(src, "://") {
+		src = "http://" + src
+	}
+
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("bad proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("bad proxy URL '%s': unsupported scheme '%s' (expected http, https, socks5 or socks5h)", u.Redacted(), u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("bad proxy URL '%s': missing host", u.Redacted())
+	}
+	return u, nil
+}
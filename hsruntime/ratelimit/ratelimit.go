@@ -0,0 +1,94 @@
+// Package ratelimit provides token-bucket rate limiting of requests, globally and per host.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter limits the rate of requests, globally and per host. The nil Limiter imposes no limit.
+type Limiter struct {
+	global *rate.Limiter            // nil if no global limit
+	hosts  map[string]*rate.Limiter // by host (hostname or hostname:port)
+}
+
+// New returns a Limiter with the given global rate (if non-empty) and per-host rates, each as accepted by ParseRate.
+// Returns nil if there are no limits.
+func New(global string, hosts map[string]string) (l *Limiter, err error) {
+	if global == "" && len(hosts) == 0 {
+		return nil, nil
+	}
+
+	l = &Limiter{hosts: make(map[string]*rate.Limiter, len(hosts))}
+	if global != "" {
+		if l.global, err = newRateLimiter(global); err != nil {
+			return nil, err
+		}
+	}
+	for host, spec := range hosts {
+		if l.hosts[host], err = newRateLimiter(spec); err != nil {
+			return nil, fmt.Errorf("host %s: %w", host, err)
+		}
+	}
+	return l, nil
+}
+
+func newRateLimiter(spec string) (*rate.Limiter, error) {
+	limit, err := ParseRate(spec)
+	if err != nil {
+		return nil, err
+	}
+	return rate.NewLimiter(limit, 1), nil // burst of 1: requests are evenly spaced
+}
+
+// ParseRate parses a rate as "N/PERIOD", meaning N requests per PERIOD, where PERIOD is a duration (e.g. "500ms") or a
+// unit (e.g. "s", "m", "h"). Examples: "50/s", "1000/h", "1/200ms".
+func ParseRate(spec string) (rate.Limit, error) {
+	nStr, periodStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, fmt.Errorf("bad rate '%s': expected N/PERIOD (e.g. 50/s)", spec)
+	}
+
+	n, err := strconv.ParseFloat(nStr, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad rate '%s': count must be a positive number", spec)
+	}
+	if periodStr != "" && (periodStr[0] < '0' || periodStr[0] > '9') && periodStr[0] != '.' {
+		periodStr = "1" + periodStr // bare unit
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("bad rate '%s': period must be a positive duration or unit (e.g. s, m, 100ms)", spec)
+	}
+	return rate.Limit(n / period.Seconds()), nil
+}
+
+// Wait blocks until a request to u is permitted by both its host's limit (if any) and the global limit (if any), or ctx
+// is done.
+func (l *Limiter) Wait(ctx context.Context, u *url.URL) error {
+	if l == nil {
+		return nil
+	}
+
+	hostLim := l.hosts[u.Host]
+	if hostLim == nil {
+		hostLim = l.hosts[u.Hostname()]
+	}
+	if hostLim != nil {
+		if err := hostLim.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limit: %w", err)
+		}
+	}
+	if l.global != nil {
+		if err := l.global.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limit: %w", err)
+		}
+	}
+	return nil
+}
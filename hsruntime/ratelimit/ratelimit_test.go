@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    rate.Limit
+		wantErr bool
+	}{
+		{spec: "50/s", want: 50},
+		{spec: "120/m", want: 2},
+		{spec: "1/500ms", want: 2},
+		{spec: "3600/h", want: 1},
+		{spec: "2.5/1s", want: 2.5},
+		{spec: "50", wantErr: true},
+		{spec: "0/s", wantErr: true},
+		{spec: "x/s", wantErr: true},
+		{spec: "5/", wantErr: true},
+		{spec: "5/fortnight", wantErr: true},
+		{spec: "5/-1s", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			got, err := ParseRate(test.spec)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			} else if got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l, err := New("", map[string]string{"slow.example.com": "10/s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(rawURL string, n int) time.Duration {
+		u, _ := url.Parse(rawURL)
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			if err := l.Wait(context.Background(), u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return time.Since(start)
+	}
+
+	if d := wait("https://fast.example.com/", 100); d > 50*time.Millisecond {
+		t.Errorf("unlimited host was limited: took %v", d)
+	}
+	if d := wait("https://slow.example.com:443/", 4); d < 250*time.Millisecond { // first is immediate, then 100ms apart
+		t.Errorf("limited host was not limited: took %v", d)
+	}
+
+	if l, err := New("", nil); err != nil || l != nil {
+		t.Errorf("expected nil Limiter for no limits, got %v, %v", l, err)
+	}
+}
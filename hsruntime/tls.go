@@ -0,0 +1,277 @@
+package hsruntime
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// TLSOptions configure TLS for https requests. Zero values mean the default; config TLS, if bound, adds CA files and
+// client certificates, and sets the other settings if not given.
+type TLSOptions struct {
+	CAFiles     []string     // PEM files of CA certificates to trust, in addition to the system's
+	ClientCerts []ClientCert // client certificates (for mTLS); the first matching a request's host is used
+	MinVersion  string       // min. TLS version, one of TLSVersions ("" = 1.2)
+	ServerName  string       // if non-empty, overrides the server name sent (SNI) and verified, for all hosts
+	Insecure    bool         // if set, server certificates are not verified
+}
+
+var TLSVersions = []string{"1.0", "1.1", "1.2", "1.3"}
+
+var tlsVersionIds = map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// ClientCert is a client certificate and key, for requests to hosts matching Host.
+type ClientCert struct {
+	Host     string // hostname glob (as path.Match, e.g. "*.internal.example.com"); "" matches all hosts
+	CertFile string // PEM file of the certificate (chain)
+	KeyFile  string // PEM file of the key; "" if in CertFile
+}
+
+// ParseClientCert parses a client certificate spec "[HOST=]CERTFILE[,KEYFILE]".
+func ParseClientCert(spec string) (ClientCert, error) {
+	var cc ClientCert
+	if host, rest, ok := strings.Cut(spec, "="); ok {
+		cc.Host, spec = host, rest
+	}
+	cc.CertFile, cc.KeyFile, _ = strings.Cut(spec, ",")
+	if cc.CertFile == "" {
+		return ClientCert{}, fmt.Errorf("bad client certificate '%s': expected [HOST=]CERTFILE[,KEYFILE]", spec)
+	} else if _, err := path.Match(cc.Host, ""); err != nil {
+		return ClientCert{}, fmt.Errorf("bad client certificate host '%s': %w", cc.Host, err)
+	}
+	return cc, nil
+}
+
+// matchHost returns whether hostname host matches glob ("" matches all hosts).
+func matchHost(glob, host string) bool {
+	if glob == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(glob), strings.ToLower(host))
+	return ok
+}
+
+var tlsConfigKeys = []string{"ca_files", "client_certs", "min_version", "server_name", "insecure"}
+
+// defaultTLSOptions returns opts, with settings from config TLS, if bound, added.
+func defaultTLSOptions(opts TLSOptions, globals scope.ScopedBindings) (TLSOptions, error) {
+	cfgIntf, _ := globals.Lookup("TLS")
+
+	cfg, ok := cfgIntf.(map[string]interface{})
+	if cfgIntf == nil {
+		return opts, nil
+	} else if !ok {
+		return opts, fmt.Errorf("expected map for TLS, got %T", cfgIntf)
+	}
+
+	for key, v := range cfg {
+		var err error
+		switch key {
+		case "ca_files":
+			var files []string
+			if files, err = configStrings(v); err == nil {
+				opts.CAFiles = append(opts.CAFiles, files...)
+			}
+		case "client_certs":
+			var certs []ClientCert
+			if certs, err = configClientCerts(v); err == nil {
+				opts.ClientCerts = append(opts.ClientCerts, certs...)
+			}
+		case "min_version":
+			if opts.MinVersion == "" {
+				if f, ok := v.(float64); ok { // e.g. unquoted 1.2 in YAML
+					v = strconv.FormatFloat(f, 'f', 1, 64)
+				}
+				opts.MinVersion, err = configString(v)
+			}
+		case "server_name":
+			if opts.ServerName == "" {
+				opts.ServerName, err = configString(v)
+			}
+		case "insecure":
+			if !opts.Insecure {
+				if opts.Insecure, ok = v.(bool); !ok {
+					err = fmt.Errorf("expected bool, got %T", v)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown setting (expected one of: %s)", strings.Join(tlsConfigKeys, " "))
+		}
+		if err != nil {
+			return opts, fmt.Errorf("bad TLS setting '%s': %w", key, err)
+		}
+	}
+	return opts, nil
+}
+
+// configStrings parses a string, or array of strings.
+func configStrings(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		out := make([]string, len(v))
+		for i, elem := range v {
+			s, ok := elem.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string or array of strings, got array containing %T", elem)
+			}
+			out[i] = s
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("expected string or array of strings, got %T", v)
+}
+
+// configClientCerts parses an array of objects {host, cert, key}, or of specs as ParseClientCert.
+func configClientCerts(v interface{}) ([]ClientCert, error) {
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected array, got %T", v)
+	}
+	out := make([]ClientCert, len(arr))
+	for i, elem := range arr {
+		var err error
+		switch elem := elem.(type) {
+		case string:
+			out[i], err = ParseClientCert(elem)
+		case map[string]interface{}:
+			var cc ClientCert
+			for k, field := range map[string]*string{"host": &cc.Host, "cert": &cc.CertFile, "key": &cc.KeyFile} {
+				if elem[k] != nil {
+					if *field, err = configString(elem[k]); err != nil {
+						return nil, fmt.Errorf("client certificate '%s': %w", k, err)
+					}
+				}
+			}
+			if cc.CertFile == "" {
+				return nil, fmt.Errorf("client certificate missing 'cert'")
+			}
+			out[i] = cc
+		default:
+			err = fmt.Errorf("expected client certificate object or string, got %T", elem)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// hostCert is a loaded ClientCert.
+type hostCert struct {
+	host string
+	cert tls.Certificate
+}
+
+// newTLSConfig returns the TLS config per opts, without client certificates, and the loaded client certificates.
+func newTLSConfig(opts TLSOptions) (cfg *tls.Config, certs []hostCert, err error) {
+	cfg = &tls.Config{ServerName: opts.ServerName, InsecureSkipVerify: opts.Insecure}
+
+	if opts.MinVersion != "" {
+		var ok bool
+		if cfg.MinVersion, ok = tlsVersionIds[opts.MinVersion]; !ok {
+			return nil, nil, fmt.Errorf("bad min. TLS version '%s' (expected one of: %s)", opts.MinVersion, strings.Join(TLSVersions, " "))
+		}
+	}
+
+	if len(opts.CAFiles) > 0 {
+		if cfg.RootCAs, err = x509.SystemCertPool(); err != nil {
+			cfg.RootCAs = x509.NewCertPool() // e.g. no system pool on this platform
+		}
+		for _, file := range opts.CAFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read CA file: %w", err)
+			} else if !cfg.RootCAs.AppendCertsFromPEM(data) {
+				return nil, nil, fmt.Errorf("no PEM certificates found in CA file %s", file)
+			}
+		}
+	}
+
+	for _, cc := range opts.ClientCerts {
+		keyFile := cc.KeyFile
+		if keyFile == "" {
+			keyFile = cc.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load client certificate %s: %w", cc.CertFile, err)
+		}
+		certs = append(certs, hostCert{host: cc.Host, cert: cert})
+	}
+	return cfg, certs, nil
+}
+
+// newRoundTripper returns the transport for requests, per timeouts t, tuning o, TLS options tlsOpts and proxy options
+// proxyOpts. If client certificates are for specific hosts, requests are routed by host to transports with the matching
+// certificate, since a TLS client cannot choose its certificate by host.
+func newRoundTripper(t Timeouts, o TransportOptions, tlsOpts TLSOptions, proxyOpts ProxyOptions) (http.RoundTripper, error) {
+	tlsCfg, certs, err := newTLSConfig(tlsOpts)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newProxyFunc(proxyOpts)
+	if err != nil {
+		return nil, err
+	} else if o.HTTP2 == HTTP2Force && proxyOpts.Proxy != "" && proxyOpts.Proxy != ProxyDirect {
+		return nil, fmt.Errorf("cannot use a proxy with HTTP/2 mode %s", HTTP2Force)
+	}
+
+	// Certificates up to and including the first for all hosts are selected by host; any after are unreachable
+	rt := &hostRoundTripper{}
+	for _, hc := range certs {
+		cfg := tlsCfg.Clone()
+		cfg.Certificates = []tls.Certificate{hc.cert}
+		tr, err := newTransport(t, o, cfg, proxy)
+		if err != nil {
+			return nil, err
+		} else if hc.host == "" {
+			rt.fallback = tr
+			break
+		}
+		rt.hosts = append(rt.hosts, hc.host)
+		rt.transports = append(rt.transports, tr)
+	}
+	if rt.fallback == nil {
+		if rt.fallback, err = newTransport(t, o, tlsCfg, proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rt.hosts) == 0 {
+		return rt.fallback, nil
+	}
+	return rt, nil
+}
+
+// hostRoundTripper routes requests to transports by URL hostname.
+type hostRoundTripper struct {
+	hosts      []string // hostname globs
+	transports []*http.Transport
+	fallback   *http.Transport
+}
+
+func (rt *hostRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	for i, glob := range rt.hosts {
+		if matchHost(glob, host) {
+			return rt.transports[i].RoundTrip(req)
+		}
+	}
+	return rt.fallback.RoundTrip(req)
+}
+
+func (rt *hostRoundTripper) CloseIdleConnections() {
+	for _, tr := range rt.transports {
+		tr.CloseIdleConnections()
+	}
+	rt.fallback.CloseIdleConnections()
+}
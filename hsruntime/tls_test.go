@@ -0,0 +1,115 @@
+package hsruntime
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClientCert(t *testing.T) {
+	tests := []struct {
+		Spec    string
+		Expect  ClientCert
+		WantErr bool
+	}{
+		{Spec: "cert.pem", Expect: ClientCert{CertFile: "cert.pem"}},
+		{Spec: "cert.pem,key.pem", Expect: ClientCert{CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{Spec: "*.internal=cert.pem,key.pem", Expect: ClientCert{Host: "*.internal", CertFile: "cert.pem", KeyFile: "key.pem"}},
+		{Spec: "host=", WantErr: true},
+		{Spec: "[=cert.pem", WantErr: true},
+	}
+	for _, tst := range tests {
+		cc, err := ParseClientCert(tst.Spec)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%s': unexpected error: %v", tst.Spec, err)
+		} else if cc != tst.Expect {
+			t.Errorf("test '%s': expected %+v, got %+v", tst.Spec, tst.Expect, cc)
+		}
+	}
+}
+
+func TestNewRoundTripperClientCerts(t *testing.T) {
+	certFile, keyFile, certPool := writeTestClientCert(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: certPool}
+	srv.StartTLS()
+	defer srv.Close()
+	port := srv.URL[strings.LastIndexByte(srv.URL, ':'):]
+
+	// Client certificate only for localhost; 127.0.0.1 is the same server, by another name
+	rt, err := newRoundTripper(Timeouts{}, TransportOptions{}, TLSOptions{
+		Insecure:    true, // test server's certificate isn't valid for localhost
+		ClientCerts: []ClientCert{{Host: "localhost", CertFile: certFile, KeyFile: keyFile}},
+	}, ProxyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rt}
+
+	if resp, err := client.Get("https://localhost" + port); err != nil {
+		t.Errorf("expected request with client certificate to succeed, got %v", err)
+	} else {
+		_ = resp.Body.Close()
+	}
+	if resp, err := client.Get("https://127.0.0.1" + port); err == nil {
+		_ = resp.Body.Close()
+		t.Errorf("expected request without client certificate to fail")
+	}
+}
+
+// writeTestClientCert writes a self-signed client certificate and key to PEM files, returning them and a pool trusting
+// the certificate.
+func writeTestClientCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
@@ -0,0 +1,271 @@
+package hsruntime
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// HTTP/2 modes (see TransportOptions).
+const (
+	HTTP2Auto  = "auto"  // HTTP/2 if the server supports it (negotiated via TLS), otherwise HTTP/1.1
+	HTTP2Off   = "off"   // HTTP/1.1 only
+	HTTP2Force = "force" // HTTP/2 only: via TLS for https (failing if unsupported), and h2c for http
+	HTTP2H2C   = "h2c"   // as auto, but h2c (cleartext HTTP/2, with prior knowledge) for http
+)
+
+var HTTP2Modes = []string{HTTP2Auto, HTTP2Off, HTTP2Force, HTTP2H2C}
+
+// TransportOptions tune the HTTP transport. Zero values mean the default, and are set from config TRANSPORT, if bound.
+type TransportOptions struct {
+	HTTP2 string // one of HTTP2Modes ("" = auto)
+
+	MaxConnsPerHost     int           // max. connections per host, including those in use (0 = no limit)
+	MaxIdleConns        int           // max. idle (keep-alive) connections, across all hosts (0 = 100)
+	MaxIdleConnsPerHost int           // max. idle connections per host (0 = the greater of 2 and Parallel)
+	IdleConnTimeout     time.Duration // time after which idle connections are closed (0 = 90s)
+	KeepAlive           time.Duration // TCP keep-alive probe interval (0 = 30s; negative disables probes)
+	DisableKeepAlives   bool          // if set, each request uses a new connection
+
+	Parallel int // expected max. concurrent requests, which sizes the idle pool by default
+}
+
+// transportConfigKeys are the keys of the TRANSPORT config object.
+var transportConfigKeys = []string{"http2", "max_conns_per_host", "max_idle_conns", "max_idle_conns_per_host", "idle_timeout", "keepalive", "disable_keepalives"}
+
+// defaultTransportOptions returns opts, with settings not given set from config TRANSPORT, if bound.
+func defaultTransportOptions(opts TransportOptions, globals scope.ScopedBindings) (TransportOptions, error) {
+	cfgIntf, _ := globals.Lookup("TRANSPORT")
+
+	cfg, ok := cfgIntf.(map[string]interface{})
+	if cfgIntf == nil {
+		return opts, nil
+	} else if !ok {
+		return opts, fmt.Errorf("expected map for TRANSPORT, got %T", cfgIntf)
+	}
+
+	for key, v := range cfg {
+		var err error
+		switch key {
+		case "http2":
+			if opts.HTTP2 == "" {
+				opts.HTTP2, err = configString(v)
+			}
+		case "max_conns_per_host":
+			if opts.MaxConnsPerHost == 0 {
+				opts.MaxConnsPerHost, err = configInt(v)
+			}
+		case "max_idle_conns":
+			if opts.MaxIdleConns == 0 {
+				opts.MaxIdleConns, err = configInt(v)
+			}
+		case "max_idle_conns_per_host":
+			if opts.MaxIdleConnsPerHost == 0 {
+				opts.MaxIdleConnsPerHost, err = configInt(v)
+			}
+		case "idle_timeout":
+			if opts.IdleConnTimeout == 0 {
+				opts.IdleConnTimeout, err = configDuration(v)
+			}
+		case "keepalive":
+			if opts.KeepAlive == 0 {
+				opts.KeepAlive, err = configDuration(v)
+			}
+		case "disable_keepalives":
+			if !opts.DisableKeepAlives {
+				opts.DisableKeepAlives, ok = v.(bool)
+				if !ok {
+					err = fmt.Errorf("expected bool, got %T", v)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown setting (expected one of: %s)", strings.Join(transportConfigKeys, " "))
+		}
+		if err != nil {
+			return opts, fmt.Errorf("bad TRANSPORT setting '%s': %w", key, err)
+		}
+	}
+	return opts, nil
+}
+
+func configString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("expected string, got %T", v)
+}
+
+func configInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("expected integer, got %v", v)
+}
+
+// configDuration parses a duration, as a string (e.g. "30s") or a number of seconds.
+func configDuration(v interface{}) (time.Duration, error) {
+	switch v := v.(type) {
+	case string:
+		return time.ParseDuration(v)
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("expected duration, got %T", v)
+}
+
+// newTransport returns a Transport as http.DefaultTransport, but with timeouts t, tuning o, TLS config tlsCfg (nil for
+// the default) and proxy selection proxy (nil for the default, from the environment).
+//
+// HTTP/2 connections made by HTTP2Force or HTTP2H2C are multiplexed, one per host: o.MaxConnsPerHost, if set, makes
+// requests beyond the server's concurrent stream limit wait (rather than opening more connections), o.DisableKeepAlives
+// closes each connection after its request, and the idle connection limits don't apply (so are an error with
+// HTTP2Force). The response header timeout doesn't apply, and requests for which proxy selects a proxy fail, since they
+// can't be made via proxies.
+func newTransport(t Timeouts, o TransportOptions, tlsCfg *tls.Config, proxy func(*http.Request) (*url.URL, error)) (*http.Transport, error) {
+	dialer := &net.Dialer{Timeout: t.Connect, KeepAlive: o.KeepAlive}
+	if o.KeepAlive == 0 {
+		dialer.KeepAlive = 30 * time.Second // as http.DefaultTransport
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialer.DialContext
+	tr.TLSHandshakeTimeout = t.TLSHandshake
+	tr.ResponseHeaderTimeout = t.ResponseHeader
+	tr.TLSClientConfig = tlsCfg
+	if proxy != nil {
+		tr.Proxy = proxy
+	}
+	h2 := func(rt http.RoundTripper) http.RoundTripper {
+		return h2RoundTripper{rt: rt, proxy: tr.Proxy, mode: o.HTTP2, closeConns: o.DisableKeepAlives}
+	}
+	strictStreams := o.MaxConnsPerHost > 0
+
+	tr.MaxConnsPerHost = o.MaxConnsPerHost
+	if o.MaxIdleConns > 0 {
+		tr.MaxIdleConns = o.MaxIdleConns
+	}
+	tr.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	if tr.MaxIdleConnsPerHost == 0 {
+		tr.MaxIdleConnsPerHost = max(http.DefaultMaxIdleConnsPerHost, o.Parallel)
+	}
+	if tr.MaxIdleConns > 0 && tr.MaxIdleConns < tr.MaxIdleConnsPerHost {
+		tr.MaxIdleConns = tr.MaxIdleConnsPerHost // else, the per-host pool is limited by the total
+	}
+	if o.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = o.IdleConnTimeout
+	}
+	tr.DisableKeepAlives = o.DisableKeepAlives
+
+	switch o.HTTP2 {
+	case "", HTTP2Auto:
+	case HTTP2Off:
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper) // non-nil and empty disables HTTP/2
+	case HTTP2Force:
+		if o.MaxIdleConns > 0 || o.MaxIdleConnsPerHost > 0 {
+			return nil, fmt.Errorf("idle connection limits don't apply with HTTP/2 mode %s (connections are multiplexed)", HTTP2Force)
+		}
+		tr.RegisterProtocol("https", h2(&http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialTLSH2(ctx, dialer, tr, t.TLSHandshake, network, addr)
+			},
+			IdleConnTimeout:            tr.IdleConnTimeout,
+			StrictMaxConcurrentStreams: strictStreams,
+		}))
+		tr.RegisterProtocol("http", h2(newH2CTransport(dialer, tr.IdleConnTimeout, strictStreams)))
+	case HTTP2H2C:
+		tr.RegisterProtocol("http", h2(newH2CTransport(dialer, tr.IdleConnTimeout, strictStreams)))
+	default:
+		return nil, fmt.Errorf("bad HTTP/2 mode '%s' (expected one of: %s)", o.HTTP2, strings.Join(HTTP2Modes, " "))
+	}
+	return tr, nil
+}
+
+// h2RoundTripper sends requests via rt, an http2.Transport, which can't make them via proxies: those for which proxy
+// selects one fail (rather than silently connecting directly). If closeConns is set, each request's connection is
+// closed after it.
+type h2RoundTripper struct {
+	rt         http.RoundTripper
+	proxy      func(*http.Request) (*url.URL, error)
+	mode       string // HTTP/2 mode, for errors
+	closeConns bool
+}
+
+func (p h2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if u, err := p.proxy(req); err != nil {
+		return nil, err
+	} else if u != nil {
+		return nil, fmt.Errorf("cannot send via proxy %s with HTTP/2 mode %s (use --no-proxy for this host, or another mode)", u.Redacted(), p.mode)
+	}
+	if p.closeConns && !req.Close {
+		req = req.Clone(req.Context())
+		req.Close = true
+	}
+	return p.rt.RoundTrip(req)
+}
+
+func (p h2RoundTripper) CloseIdleConnections() {
+	if c, ok := p.rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// newH2CTransport returns a transport for cleartext HTTP/2 with prior knowledge.
+func newH2CTransport(dialer *net.Dialer, idleTimeout time.Duration, strictStreams bool) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr) // not TLS
+		},
+		IdleConnTimeout:            idleTimeout,
+		StrictMaxConcurrentStreams: strictStreams,
+	}
+}
+
+// dialTLSH2 dials addr, and performs a TLS handshake (per tr's TLS config, within timeout) that must negotiate HTTP/2.
+func dialTLSH2(ctx context.Context, dialer *net.Dialer, tr *http.Transport, timeout time.Duration, network, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{}
+	if tr.TLSClientConfig != nil {
+		cfg = tr.TLSClientConfig.Clone()
+	}
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	} else if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+		_ = conn.Close()
+		return nil, fmt.Errorf("server does not support HTTP/2 (negotiated protocol '%s')", proto)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,117 @@
+package hsruntime
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestNewTransportHTTP2(t *testing.T) {
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(r.Proto)) })
+
+	tlsH2 := httptest.NewUnstartedServer(protoHandler)
+	tlsH2.EnableHTTP2 = true
+	tlsH2.StartTLS()
+	defer tlsH2.Close()
+
+	tlsH1 := httptest.NewTLSServer(protoHandler)
+	defer tlsH1.Close()
+
+	cleartext := httptest.NewServer(h2c.NewHandler(protoHandler, &http2.Server{}))
+	defer cleartext.Close()
+
+	tests := []struct {
+		Mode    string
+		Server  *httptest.Server
+		Expect  string
+		WantErr bool
+	}{
+		{Mode: HTTP2Auto, Server: tlsH2, Expect: "HTTP/2.0"},
+		{Mode: HTTP2Auto, Server: tlsH1, Expect: "HTTP/1.1"},
+		{Mode: HTTP2Auto, Server: cleartext, Expect: "HTTP/1.1"},
+		{Mode: HTTP2Off, Server: tlsH2, Expect: "HTTP/1.1"},
+		{Mode: HTTP2Force, Server: tlsH2, Expect: "HTTP/2.0"},
+		{Mode: HTTP2Force, Server: tlsH1, WantErr: true},
+		{Mode: HTTP2Force, Server: cleartext, Expect: "HTTP/2.0"},
+		{Mode: HTTP2H2C, Server: tlsH2, Expect: "HTTP/2.0"},
+		{Mode: HTTP2H2C, Server: cleartext, Expect: "HTTP/2.0"},
+	}
+	for _, tst := range tests {
+		var tlsCfg *tls.Config
+		if cfg := tst.Server.Client().Transport.(*http.Transport).TLSClientConfig; cfg != nil { // trust test cert
+			tlsCfg = cfg.Clone()
+			tlsCfg.NextProtos = nil // as set by the transport
+		}
+		tr, err := newTransport(Timeouts{}, TransportOptions{HTTP2: tst.Mode}, tlsCfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := (&http.Client{Transport: tr}).Get(tst.Server.URL)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("mode %s, %s: unexpected error: %v", tst.Mode, tst.Server.URL, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.Proto != tst.Expect {
+			t.Errorf("mode %s, %s: expected %s, got %s", tst.Mode, tst.Server.URL, tst.Expect, resp.Proto)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
+func TestNewTransportIdlePool(t *testing.T) {
+	tr, err := newTransport(Timeouts{}, TransportOptions{Parallel: 256}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.MaxIdleConnsPerHost != 256 || tr.MaxIdleConns != 256 {
+		t.Errorf("expected idle pool sized to parallelism 256, got %d per host, %d total", tr.MaxIdleConnsPerHost, tr.MaxIdleConns)
+	}
+}
+
+func TestNewTransportHTTP2Pool(t *testing.T) {
+	if _, err := newTransport(Timeouts{}, TransportOptions{HTTP2: HTTP2Force, MaxIdleConnsPerHost: 8}, nil, nil); err == nil {
+		t.Errorf("expected error for idle connection limit with HTTP/2 mode force")
+	}
+
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &http2.Server{}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	for _, disableKeepAlives := range []bool{false, true} {
+		conns.Store(0)
+		tr, err := newTransport(Timeouts{}, TransportOptions{HTTP2: HTTP2H2C, MaxConnsPerHost: 1, DisableKeepAlives: disableKeepAlives}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		tr.CloseIdleConnections()
+
+		if expect := map[bool]int32{false: 1, true: 3}[disableKeepAlives]; conns.Load() != expect {
+			t.Errorf("disable keep-alives %v: expected %d connections, got %d", disableKeepAlives, expect, conns.Load())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -10,10 +11,44 @@ import (
 	"github.com/daboyuka/hs/program/scope"
 )
 
-func RunParallel(ctx context.Context, cmd Command, binds *scope.Bindings, input record.Stream, output record.Sink, n int, counter *atomic.Uint64) (finalErr error) {
+// Stages at which an ErrorHandler may be called.
+const (
+	StageInput = "input" // reading an input record
+	StageBuild = "build" // running the command on an input record
+	StageRun   = "run"   // reading the command's outputs for an input record
+)
+
+// ErrorHandler is called on an error at stage (one of the Stage constants) while processing input record in (nil if
+// StageInput). If it returns nil, processing continues with the next input record; otherwise, processing aborts with
+// the returned error. A nil ErrorHandler aborts on all errors.
+//
+// An ErrorHandler is only called for input errors that are record.RecordErrors (others always abort), and may be called
+// concurrently.
+type ErrorHandler func(stage string, in record.Record, err error) error
+
+func RunParallel(ctx context.Context, cmd Command, binds *scope.Bindings, input record.Stream, output record.Sink, n int, onError ErrorHandler, counter *atomic.Uint64) (finalErr error) {
+	return runParallel(ctx, cmd, binds, input, output, n, onError, counter, nil)
+}
+
+// RunParallelOrdered is as RunParallel, but sinks the outputs for each input record in input order. At most window
+// input records (at least n) are in progress or awaiting output at once, beginning at the earliest not yet output.
+func RunParallelOrdered(ctx context.Context, cmd Command, binds *scope.Bindings, input record.Stream, output record.Sink, n, window int, onError ErrorHandler, counter *atomic.Uint64) (finalErr error) {
+	if n <= 0 {
+		n = 1
+	}
+	if window < n {
+		window = n
+	}
+	return runParallel(ctx, cmd, binds, input, output, n, onError, counter, newReorderBuffer(window))
+}
+
+func runParallel(ctx context.Context, cmd Command, binds *scope.Bindings, input record.Stream, output record.Sink, n int, onError ErrorHandler, counter *atomic.Uint64, reorder *reorderBuffer) (finalErr error) {
 	if n <= 0 {
 		n = 1
 	}
+	if onError == nil {
+		onError = func(stage string, in record.Record, err error) error { return err }
+	}
 
 	wg := sync.WaitGroup{}
 	defer wg.Wait() // don't return until everything's shut down
@@ -28,35 +63,42 @@ func RunParallel(ctx context.Context, cmd Command, binds *scope.Bindings, input
 			defer wg.Done()
 
 			for {
-				in, err := input.Next()
+				var seq uint64
+				var in record.Record
+				var err error
+				if reorder != nil {
+					seq, in, err = reorder.next(ctx, input)
+				} else {
+					in, err = input.Next()
+				}
 				if err == io.EOF {
 					return
 				} else if err != nil {
-					errCh <- err
-					return
+					if errors.As(err, new(record.RecordError)) {
+						err = onError(StageInput, nil, err)
+					}
+					if err != nil {
+						errCh <- err
+						return
+					}
+					continue
 				}
 
-				out, _, err := cmd.Run(ctx, in, binds)
+				sinkOuts, err := startOne(ctx, cmd, in, binds, onError)
+				if err == nil {
+					if counter != nil {
+						counter.Add(1)
+					}
+					if reorder != nil {
+						err = reorder.complete(seq, sinkOuts, output)
+					} else {
+						err = sinkOuts(output)
+					}
+				}
 				if err != nil {
 					errCh <- err
 					return
 				}
-				if counter != nil {
-					counter.Add(1)
-				}
-
-				for {
-					rec, err := out.Next()
-					if err == io.EOF {
-						break
-					} else if err != nil {
-						errCh <- err
-						return
-					} else if err := output.Sink(rec); err != nil {
-						errCh <- err
-						return
-					}
-				}
 			}
 		}()
 	}
@@ -71,3 +113,92 @@ func RunParallel(ctx context.Context, cmd Command, binds *scope.Bindings, input
 	}
 	return nil
 }
+
+// startOne runs cmd on in, returning a function to sink its outputs. Errors are handled by onError; if it returns nil,
+// outputs (or remaining outputs) are skipped.
+func startOne(ctx context.Context, cmd Command, in record.Record, binds *scope.Bindings, onError ErrorHandler) (sinkOuts func(output record.Sink) error, err error) {
+	handle := func(stage string, err error) error {
+		if ctx.Err() != nil {
+			return err // cancelled; not an error of this record
+		}
+		return onError(stage, in, err)
+	}
+
+	out, _, err := cmd.Run(ctx, in, binds)
+	if err != nil {
+		if err := handle(StageBuild, err); err != nil {
+			return nil, err
+		}
+		return func(record.Sink) error { return nil }, nil
+	}
+
+	return func(output record.Sink) error {
+		for {
+			rec, err := out.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return handle(StageRun, err)
+			} else if err := output.Sink(rec); err != nil {
+				return err
+			}
+		}
+	}, nil
+}
+
+// reorderBuffer sequences input records, and holds the outputs of each until those of all prior inputs are sunk.
+type reorderBuffer struct {
+	slots chan struct{} // one per input record read whose outputs are not yet sunk, bounding the window
+
+	inMtx  sync.Mutex
+	nextIn uint64 // sequence number of next input record
+
+	outMtx  sync.Mutex
+	nextOut uint64                                    // sequence number of next input record whose outputs are to be sunk
+	pending map[uint64]func(output record.Sink) error // sinks outputs, by input sequence number
+}
+
+func newReorderBuffer(window int) *reorderBuffer {
+	return &reorderBuffer{slots: make(chan struct{}, window), pending: make(map[uint64]func(record.Sink) error)}
+}
+
+// next reads the next input record, once it is within the window, returning it with its sequence number.
+func (rb *reorderBuffer) next(ctx context.Context, input record.Stream) (seq uint64, in record.Record, err error) {
+	select {
+	case rb.slots <- struct{}{}:
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+
+	rb.inMtx.Lock()
+	defer rb.inMtx.Unlock()
+
+	if in, err = input.Next(); err != nil {
+		<-rb.slots
+		return 0, nil, err
+	}
+	seq = rb.nextIn
+	rb.nextIn++
+	return seq, in, nil
+}
+
+// complete records sinkOuts as sinking the outputs for input record seq, then sinks all outputs now in order.
+func (rb *reorderBuffer) complete(seq uint64, sinkOuts func(output record.Sink) error, output record.Sink) error {
+	rb.outMtx.Lock()
+	defer rb.outMtx.Unlock()
+
+	rb.pending[seq] = sinkOuts
+	for {
+		sinkOuts, ok := rb.pending[rb.nextOut]
+		if !ok {
+			return nil
+		}
+		delete(rb.pending, rb.nextOut)
+		rb.nextOut++
+		<-rb.slots
+
+		if err := sinkOuts(output); err != nil {
+			return err
+		}
+	}
+}
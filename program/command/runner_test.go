@@ -0,0 +1,187 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// delayCommand outputs each input twice, after a random delay.
+type delayCommand struct{}
+
+func (delayCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (record.Stream, *scope.Bindings, error) {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	return &record.SliceStream{Records: []record.Record{in, in}}, binds, nil
+}
+
+type collectSink struct {
+	mtx  sync.Mutex
+	recs record.Array
+}
+
+func (c *collectSink) Sink(rec record.Record) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.recs = append(c.recs, rec)
+	return nil
+}
+
+func TestRunParallelOrdered(t *testing.T) {
+	var input []record.Record
+	var expect record.Array
+	for i := 0; i < 200; i++ {
+		input = append(input, float64(i))
+		expect = append(expect, float64(i), float64(i))
+	}
+
+	sink := &collectSink{}
+	err := RunParallelOrdered(context.Background(), delayCommand{}, nil, &record.SliceStream{Records: input}, sink, 8, 16, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(sink.recs, expect) {
+		t.Errorf("got %v, expect %v", sink.recs, expect)
+	}
+}
+
+// failCommand outputs each input, failing to build on input "build", and failing while running on input "run".
+type failCommand struct{}
+
+func (failCommand) Run(ctx context.Context, in record.Record, binds *scope.Bindings) (record.Stream, *scope.Bindings, error) {
+	switch in {
+	case "build":
+		return nil, nil, errors.New("build failed")
+	case "run":
+		return &errStream{Items: []any{in, errors.New("run failed")}}, binds, nil
+	}
+	return &record.SliceStream{Records: []record.Record{in}}, binds, nil
+}
+
+// errStream returns each of Items in turn: as an error if it is one, else as a record.
+type errStream struct {
+	mtx   sync.Mutex
+	Items []any
+}
+
+func (s *errStream) Next() (record.Record, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.Items) == 0 {
+		return nil, io.EOF
+	}
+	item := s.Items[0]
+	s.Items = s.Items[1:]
+	if err, ok := item.(error); ok {
+		return nil, err
+	}
+	return item, nil
+}
+
+type stageError struct {
+	Stage string
+	In    record.Record
+}
+
+func TestRunParallelOnError(t *testing.T) {
+	badInput := record.RecordError{Err: errors.New("bad input")}
+	tests := []struct {
+		Name        string
+		Input       []any
+		Ordered     bool
+		Abort       bool // ErrorHandler returns errors
+		ExpectOut   record.Array
+		ExpectErrs  []stageError
+		ExpectAbort bool
+	}{
+		{
+			Name:       "skip",
+			Input:      []any{"a", "build", badInput, "run", "b"},
+			ExpectOut:  record.Array{"a", "run", "b"}, // "run" fails after its first output
+			ExpectErrs: []stageError{{StageBuild, "build"}, {StageInput, nil}, {StageRun, "run"}},
+		},
+		{
+			Name:       "skip ordered",
+			Input:      []any{"a", "build", badInput, "run", "b"},
+			Ordered:    true,
+			ExpectOut:  record.Array{"a", "run", "b"},
+			ExpectErrs: []stageError{{StageBuild, "build"}, {StageInput, nil}, {StageRun, "run"}},
+		},
+		{
+			Name:        "abort",
+			Input:       []any{"build", "a"},
+			Abort:       true,
+			ExpectErrs:  []stageError{{StageBuild, "build"}},
+			ExpectAbort: true,
+		},
+		{
+			Name:        "non-record input error",
+			Input:       []any{"a", errors.New("read failed"), "b"},
+			ExpectOut:   record.Array{"a"},
+			ExpectAbort: true,
+		},
+	}
+	for _, tst := range tests {
+		var mtx sync.Mutex
+		var errs []stageError
+		onError := func(stage string, in record.Record, err error) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			errs = append(errs, stageError{stage, in})
+			if tst.Abort {
+				return err
+			}
+			return nil
+		}
+
+		sink := &collectSink{}
+		input := &errStream{Items: tst.Input}
+		var err error
+		if tst.Ordered {
+			err = RunParallelOrdered(context.Background(), failCommand{}, nil, input, sink, 1, 1, onError, nil)
+		} else {
+			err = RunParallel(context.Background(), failCommand{}, nil, input, sink, 1, onError, nil)
+		}
+		if (err != nil) != tst.ExpectAbort {
+			t.Errorf("test '%s': got error %v, expect abort %v", tst.Name, err, tst.ExpectAbort)
+		}
+		if !reflect.DeepEqual(sink.recs, tst.ExpectOut) {
+			t.Errorf("test '%s': got outputs %v, expect %v", tst.Name, sink.recs, tst.ExpectOut)
+		}
+		if !reflect.DeepEqual(errs, tst.ExpectErrs) {
+			t.Errorf("test '%s': got errors %v, expect %v", tst.Name, errs, tst.ExpectErrs)
+		}
+	}
+}
+
+func TestRunParallelOrderedSkip(t *testing.T) {
+	var input []any
+	var expect record.Array
+	for i := 0; i < 200; i++ {
+		switch i % 4 {
+		case 1:
+			input = append(input, "build")
+		case 2:
+			input = append(input, record.RecordError{Err: errors.New("bad input")})
+		default:
+			input = append(input, float64(i))
+			expect = append(expect, float64(i))
+		}
+	}
+
+	// Skipped records must leave the reorder window, or it fills and stalls
+	sink := &collectSink{}
+	skip := func(string, record.Record, error) error { return nil }
+	err := RunParallelOrdered(context.Background(), failCommand{}, nil, &errStream{Items: input}, sink, 4, 4, skip, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(sink.recs, expect) {
+		t.Errorf("got %v, expect %v", sink.recs, expect)
+	}
+}
@@ -0,0 +1,54 @@
+package expr
+
+import (
+	"strings"
+
+	"github.com/daboyuka/hs/program/record"
+	"github.com/daboyuka/hs/program/scope"
+)
+
+// Escaper transforms the string form of a value interpolated into a Template (e.g. to percent-encode it for a URL).
+type Escaper func(s string) string
+
+// EscapeContext selects the Escaper for an interpolation in a Template, given all template text preceding it, or returns
+// nil if the interpolation should not be escaped. In prefix, each earlier interpolation is replaced by Placeholder.
+type EscapeContext func(prefix string) Escaper
+
+// Placeholder stands in for an interpolated value in the prefix passed to an EscapeContext.
+const Placeholder = "\x00"
+
+// Raw wraps an Expr, exempting it from escaping when interpolated directly into a Template. Otherwise, it evaluates
+// the same as the wrapped Expr.
+type Raw struct{ Expr }
+
+func (r Raw) String() string { return "(raw " + r.Expr.String() + ")" }
+
+// Escape returns e with escaping applied to each of its interpolations, as chosen by ctx. If e is not a Template (e.g.
+// a constant, or a template consisting of a single interpolation), it is returned unchanged.
+func Escape(e Expr, ctx EscapeContext) Expr {
+	t, ok := e.(Template)
+	if !ok {
+		return e
+	}
+
+	t.Escapes = make([]Escaper, len(t.Exprs))
+	prefix := strings.Builder{}
+	for i, lit := range t.Lits[:len(t.Exprs)] {
+		prefix.WriteString(lit)
+		if _, raw := t.Exprs[i].(Raw); !raw {
+			t.Escapes[i] = ctx(prefix.String())
+		}
+		prefix.WriteString(Placeholder)
+	}
+	return t
+}
+
+func (t Template) evalInterp(i int, rec record.Record, binds *scope.Bindings) (string, error) {
+	s, err := EvalToString(t.Exprs[i], rec, binds)
+	if err != nil {
+		return "", err
+	} else if i < len(t.Escapes) && t.Escapes[i] != nil {
+		s = t.Escapes[i](s)
+	}
+	return s, nil
+}
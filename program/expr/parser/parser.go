@@ -28,6 +28,7 @@ import (
 //  field-path       ::= field-comp-first field-comp*
 //
 //  func-call ::= IDENT (WS expr)+
+//  /* the func "raw" is builtin, taking one arg: it exempts that arg from template escaping (see expr.Raw) */
 //
 //  grouping ::= '(' top-expr ')
 //
@@ -76,6 +77,8 @@ func ParseTemplate(src string, scp *scope.Scope, fns *scope.FuncTable) (expr exp
 	return p.parseTemplate(), nil
 }
 
+const rawFuncName = "raw"
+
 type parser struct {
 	lex *lex.Lex
 	scp *scope.Scope
@@ -140,7 +143,9 @@ func (p *parser) parseExpr(topExpr bool, close lex.TokenKind, closeMode lex.Mode
 			args = p.parseFuncArgs(close)
 		}
 
-		if len(args) != 0 {
+		if len(args) == 1 && name == rawFuncName {
+			e = expr.Raw{Expr: args[0]}
+		} else if len(args) != 0 {
 			fn := p.fns.Get(name)
 			if fn == nil {
 				panic(p.parseError("reference to undeclared func '" + name + "'"))
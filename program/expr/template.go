@@ -9,9 +9,11 @@ import (
 
 // Template is a string template, alternating string literals and embedded expressions (with literal at begin and end).
 // evals[i] occurs between lits[i] and lits[i+1].
+// If Escapes is non-nil, Escapes[i] (if non-nil) is applied to the string form of Exprs[i] (see Escape).
 type Template struct {
-	Lits  []string
-	Exprs []Expr
+	Lits    []string
+	Exprs   []Expr
+	Escapes []Escaper
 }
 
 func (t Template) String() string {
@@ -30,11 +32,11 @@ func (t Template) String() string {
 func (t Template) Eval(rec record.Record, binds *scope.Bindings) (record.Record, error) {
 	strs := make([]string, 0, len(t.Lits)+len(t.Exprs))
 	strs = append(strs, t.Lits[0])
-	for i, e := range t.Exprs {
-		if evaled, err := e.Eval(rec, binds); err != nil {
+	for i := range t.Exprs {
+		if evaled, err := t.evalInterp(i, rec, binds); err != nil {
 			return "", err
 		} else {
-			strs = append(strs, record.CoerceString(evaled), t.Lits[i+1])
+			strs = append(strs, evaled, t.Lits[i+1])
 		}
 	}
 	return strings.Join(strs, ""), nil
@@ -0,0 +1,70 @@
+package record
+
+import (
+	"io"
+	"math"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var cborEncMode, _ = cbor.EncOptions{Sort: cbor.SortBytewiseLexical, ShortestFloat: cbor.ShortestFloat16}.EncMode()
+
+// CBORStream parses a concatenation of CBOR data items, one Record per item.
+type CBORStream struct {
+	d   *cbor.Decoder
+	mtx sync.Mutex
+}
+
+func NewCBORStream(r io.Reader) *CBORStream {
+	return &CBORStream{d: cbor.NewDecoder(r)}
+}
+
+func (c *CBORStream) Next() (Record, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var raw any
+	if err := c.d.Decode(&raw); err != nil {
+		return nil, err // handles io.EOF too
+	}
+	return Normalize(raw)
+}
+
+// MarshalCBOR encodes r as CBOR. Integral numbers are encoded as integers.
+func MarshalCBOR(r Record) ([]byte, error) {
+	return cborEncMode.Marshal(compactInts(r))
+}
+
+// UnmarshalCBOR decodes a single CBOR data item into a Record.
+func UnmarshalCBOR(data []byte) (Record, error) {
+	var raw any
+	if err := cbor.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return Normalize(raw)
+}
+
+// compactInts returns a copy of r with integral float64 values (within int64 range) replaced by int64.
+func compactInts(r Record) any {
+	switch r := r.(type) {
+	case float64:
+		if r == math.Trunc(r) && r >= math.MinInt64 && r < math.MaxInt64 {
+			return int64(r)
+		}
+		return r
+	case Array:
+		out := make([]any, len(r))
+		for i, elem := range r {
+			out[i] = compactInts(elem)
+		}
+		return out
+	case Object:
+		out := make(map[string]any, len(r))
+		for k, elem := range r {
+			out[k] = compactInts(elem)
+		}
+		return out
+	}
+	return r
+}
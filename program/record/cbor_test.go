@@ -0,0 +1,89 @@
+package record
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	tests := []Record{
+		nil, true, "s", 1.0, -2.5, 1e21,
+		Array{1.0, "x", Array{}, Object{}},
+		Object{"a": Object{"b": Array{nil, false}}, "c": 4294967296.0},
+	}
+	for _, rec := range tests {
+		data, err := MarshalCBOR(rec)
+		if err != nil {
+			t.Errorf("test '%v': unexpected error: %v", rec, err)
+			continue
+		}
+		if got, err := UnmarshalCBOR(data); err != nil {
+			t.Errorf("test '%v': unexpected error: %v", rec, err)
+		} else if !reflect.DeepEqual(got, rec) {
+			t.Errorf("test '%v': got %v", rec, got)
+		}
+	}
+
+	// Integral numbers are encoded compactly, as integers, and others as the shortest exact float
+	if data, _ := MarshalCBOR(1.0); !bytes.Equal(data, []byte{0x01}) {
+		t.Errorf("expected 1 encoded as integer, got %x", data)
+	}
+	if data, _ := MarshalCBOR(-2.5); !bytes.Equal(data, []byte{0xf9, 0xc1, 0x00}) {
+		t.Errorf("expected -2.5 encoded as float16, got %x", data)
+	}
+}
+
+func TestCBORStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := cbor.NewEncoder(&buf)
+	for _, v := range []any{map[int]string{1: "a"}, map[string]any{"k": []byte("bin")}, uint64(7)} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Non-string map keys are converted to strings, and byte strings to strings
+	got, err := CollectStream(NewCBORStream(&buf))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expect := Array{Object{"1": "a"}, Object{"k": "bin"}, 7.0}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+
+	if _, err := NewCBORStream(bytes.NewReader([]byte{0x82, 0x01})).Next(); err == nil || err == io.EOF {
+		t.Errorf("expected error for truncated array, got %v", err)
+	}
+}
+
+func TestCBORTags(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Input  string // hex
+		Expect Record
+	}{
+		{Name: "bignum", Input: "c249010000000000000000", Expect: "18446744073709551616"},
+		{Name: "negative bignum", Input: "c349010000000000000000", Expect: "-18446744073709551617"},
+		{Name: "epoch time", Input: "c11a514b67b0", Expect: "2013-03-21T20:04:00Z"},
+		{Name: "URI", Input: "d82076687474703a2f2f7777772e6578616d706c652e636f6d", Expect: "http://www.example.com"},
+		{Name: "unknown tag", Input: "d90100a1616101", Expect: Object{"a": 1.0}},
+		{Name: "nested", Input: "81c24101", Expect: Array{"1"}},
+	}
+	for _, tst := range tests {
+		data, err := hex.DecodeString(tst.Input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := UnmarshalCBOR(data); err != nil {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+		} else if !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': got %v, expect %v", tst.Name, got, tst.Expect)
+		}
+	}
+}
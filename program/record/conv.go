@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
 )
 
 // CoerceString formats r as a string as follows:
@@ -20,6 +24,11 @@ func CoerceString(r Record) string {
 	}
 }
 
+// Truthy returns whether r is considered true as a condition: all values are true except null and false.
+func Truthy(r Record) bool {
+	return r != nil && r != false
+}
+
 var ErrNotANumber = errors.New("not a number")
 var ErrNotAnInt = errors.New("not an integer")
 
@@ -64,3 +73,81 @@ func AsStringsArray(val Record, allowString bool) (strs []string, err error) {
 	}
 	return nil, fmt.Errorf("expected string or array-of-strings, got %T", val)
 }
+
+// Normalize converts a value produced by a non-JSON decoder (e.g. YAML) into a Record:
+//   - integer and float32 types become float64
+//   - []byte becomes string
+//   - map keys are converted to strings (as by CoerceString on the normalized key)
+//   - time.Time values (e.g. MessagePack and CBOR timestamps) become RFC 3339 strings
+//   - big.Int values (e.g. CBOR bignums) become decimal strings, as they may not fit a float64 exactly
+//   - CBOR tags (other than times and bignums) become their content, dropping the tag number
+//   - arrays and maps are normalized recursively
+//
+// Any other type results in an error.
+func Normalize(v any) (Record, error) {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case []byte:
+		return string(v), nil
+	case []any:
+		out := make(Array, len(v))
+		for i, elem := range v {
+			var err error
+			if out[i], err = Normalize(elem); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]any:
+		out := make(Object, len(v))
+		for k, elem := range v {
+			var err error
+			if out[k], err = Normalize(elem); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[any]any:
+		out := make(Object, len(v))
+		for k, elem := range v {
+			if kNorm, err := Normalize(k); err != nil {
+				return nil, err
+			} else if out[CoerceString(kNorm)], err = Normalize(elem); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case big.Int:
+		return v.String(), nil
+	case *big.Int:
+		return v.String(), nil
+	case cbor.Tag:
+		return Normalize(v.Content)
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
@@ -0,0 +1,228 @@
+package record
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSONEachStream parses a sequence of JSON values, producing the values selected by a path within each (see
+// NewJSONEachStream). Only the selected values are held in memory at once, so arbitrarily large documents may be
+// processed.
+type JSONEachStream struct {
+	d     json.Decoder
+	lines *lineTracker
+	path  jsonPath
+	mtx   sync.Mutex
+
+	stack   []jsonEachFrame // currently open containers selected by a prefix of path
+	atValue bool            // if true, the next value in d is selected by path[:len(stack)]
+}
+
+type jsonEachFrame struct {
+	step  jsonPathStep
+	isObj bool
+	idx   int // next array index
+}
+
+// NewJSONEachStream creates a Stream by parsing the input io.Reader as a sequence of JSON values, producing those selected
+// by path within each. path is a simple jq-like expression: a sequence of steps '.name' or '["name"]' (object field),
+// '[N]' (array index) or '[]' (each array element or object value). Examples: ".items[]", ".[]", ".data.rows[][0]".
+// A path of "." selects each value itself.
+func NewJSONEachStream(r io.Reader, path string) (*JSONEachStream, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := newLineTracker(r)
+	return &JSONEachStream{d: *json.NewDecoder(lines), lines: lines, path: p, atValue: true}, nil
+}
+
+func (j *JSONEachStream) Next() (Record, error) {
+	rec, _, err := j.NextPosition()
+	return rec, err
+}
+
+func (j *JSONEachStream) NextPosition() (out Record, line int, err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if err := j.seek(); err != nil {
+		if err == io.EOF && len(j.stack) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err // handles io.EOF too
+	}
+
+	var raw json.RawMessage
+	if err := j.d.Decode(&raw); err != nil {
+		return nil, 0, err
+	}
+	j.atValue = false
+	line = j.lines.lineAt(j.d.InputOffset() - int64(len(raw)))
+	err = json.Unmarshal(raw, &out)
+	return out, line, err
+}
+
+// seek advances d to the beginning of the next value selected by the full path.
+func (j *JSONEachStream) seek() error {
+	for {
+		if !j.atValue {
+			if len(j.stack) == 0 {
+				j.atValue = true // next top-level value
+				continue
+			}
+
+			top := &j.stack[len(j.stack)-1]
+			if !j.d.More() {
+				if _, err := j.d.Token(); err != nil { // closing delimiter
+					return err
+				}
+				j.stack = j.stack[:len(j.stack)-1]
+				continue
+			}
+
+			if top.isObj {
+				key, err := j.d.Token()
+				if err != nil {
+					return err
+				}
+				j.atValue = top.step.each || key == top.step.field
+			} else {
+				j.atValue = top.step.each || top.idx == top.step.index
+				top.idx++
+			}
+			if !j.atValue {
+				if err := skipJSONValue(&j.d); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if len(j.stack) == len(j.path) {
+			return nil
+		}
+
+		j.atValue = false
+		tok, err := j.d.Token()
+		if err != nil {
+			return err
+		}
+
+		step := j.path[len(j.stack)]
+		switch tok {
+		case json.Delim('{'):
+			if step.each || step.isField {
+				j.stack = append(j.stack, jsonEachFrame{step: step, isObj: true})
+			} else if err := skipJSONContainer(&j.d); err != nil {
+				return err
+			}
+		case json.Delim('['):
+			if step.each || !step.isField {
+				j.stack = append(j.stack, jsonEachFrame{step: step})
+			} else if err := skipJSONContainer(&j.d); err != nil {
+				return err
+			}
+		}
+		// otherwise, a scalar, which contains nothing to select
+	}
+}
+
+// skipJSONValue consumes the next value from d, without retaining it.
+func skipJSONValue(d *json.Decoder) error {
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	} else if tok == json.Delim('{') || tok == json.Delim('[') {
+		return skipJSONContainer(d)
+	}
+	return nil
+}
+
+// skipJSONContainer consumes the remainder of a container whose opening delimiter has been consumed from d.
+func skipJSONContainer(d *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+type jsonPathStep struct {
+	each    bool // if true, select each array element or object value
+	isField bool // if true, select object field 'field', else array index 'index'
+	field   string
+	index   int
+}
+
+type jsonPath []jsonPathStep
+
+func parseJSONPath(src string) (path jsonPath, err error) {
+	if src == "." {
+		return nil, nil
+	}
+
+	bad := func(msg string) error { return fmt.Errorf("bad JSON path '%s': %s", src, msg) }
+
+	rest := src
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, "[") {
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, bad("empty field name")
+			}
+			path = append(path, jsonPathStep{isField: true, field: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			var step jsonPathStep
+			rest = rest[1:]
+			if strings.HasPrefix(rest, `"`) {
+				quoted, err := strconv.QuotedPrefix(rest)
+				if err != nil {
+					return nil, bad("bad quoted field name")
+				}
+				step.isField = true
+				step.field, _ = strconv.Unquote(quoted)
+				rest = rest[len(quoted):]
+			} else if end := strings.IndexByte(rest, ']'); end == 0 {
+				step.each = true
+			} else if end > 0 {
+				if step.index, err = strconv.Atoi(rest[:end]); err != nil || step.index < 0 {
+					return nil, bad("bad array index '" + rest[:end] + "'")
+				}
+				rest = rest[end:]
+			}
+			if !strings.HasPrefix(rest, "]") {
+				return nil, bad("expected ']'")
+			}
+			rest = rest[1:]
+			path = append(path, step)
+		default:
+			return nil, bad("expected '.' or '[' before each step")
+		}
+	}
+	if len(path) == 0 {
+		return nil, bad("empty path")
+	}
+	return path, nil
+}
@@ -0,0 +1,62 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackStream parses a concatenation of MessagePack values, one Record per value.
+type MsgpackStream struct {
+	d   *msgpack.Decoder
+	mtx sync.Mutex
+}
+
+func NewMsgpackStream(r io.Reader) *MsgpackStream {
+	return &MsgpackStream{d: newMsgpackDecoder(r)}
+}
+
+// newMsgpackDecoder returns a decoder that decodes maps with any key types (not only strings), for Normalize.
+func newMsgpackDecoder(r io.Reader) *msgpack.Decoder {
+	d := msgpack.NewDecoder(r)
+	d.SetMapDecoder(func(d *msgpack.Decoder) (any, error) { return d.DecodeUntypedMap() })
+	return d
+}
+
+func (m *MsgpackStream) Next() (Record, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, err := m.d.PeekCode(); err != nil {
+		return nil, err // handles io.EOF too
+	}
+	raw, err := m.d.DecodeInterface()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF // value truncated
+	} else if err != nil {
+		return nil, err
+	}
+	return Normalize(raw)
+}
+
+// MarshalMsgpack encodes r as MessagePack. Integral numbers are encoded as integers.
+func MarshalMsgpack(r Record) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	enc.SetSortMapKeys(true)
+	err := enc.Encode(r)
+	return buf.Bytes(), err
+}
+
+// UnmarshalMsgpack decodes a single MessagePack value into a Record.
+func UnmarshalMsgpack(data []byte) (Record, error) {
+	raw, err := newMsgpackDecoder(bytes.NewReader(data)).DecodeInterface()
+	if err != nil {
+		return nil, err
+	}
+	return Normalize(raw)
+}
@@ -0,0 +1,59 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	tests := []Record{
+		nil, true, "s", 1.0, -2.5, 1e21,
+		Array{1.0, "x", Array{}, Object{}},
+		Object{"a": Object{"b": Array{nil, false}}, "c": 4294967296.0},
+	}
+	for _, rec := range tests {
+		data, err := MarshalMsgpack(rec)
+		if err != nil {
+			t.Errorf("test '%v': unexpected error: %v", rec, err)
+			continue
+		}
+		if got, err := UnmarshalMsgpack(data); err != nil {
+			t.Errorf("test '%v': unexpected error: %v", rec, err)
+		} else if !reflect.DeepEqual(got, rec) {
+			t.Errorf("test '%v': got %v", rec, got)
+		}
+	}
+
+	// Integral numbers are encoded compactly, as integers
+	if data, _ := MarshalMsgpack(1.0); !bytes.Equal(data, []byte{0x01}) {
+		t.Errorf("expected 1 encoded as positive fixint, got %x", data)
+	}
+}
+
+func TestMsgpackStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	for _, v := range []any{map[int]string{1: "a"}, map[string]any{"k": []byte("bin")}, uint64(7)} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Non-string map keys are converted to strings, and binary data to strings
+	got, err := CollectStream(NewMsgpackStream(&buf))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expect := Array{Object{"1": "a"}, Object{"k": "bin"}, 7.0}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+
+	if _, err := NewMsgpackStream(bytes.NewReader([]byte{0x92, 0x01})).Next(); err == nil || err == io.EOF {
+		t.Errorf("expected error for truncated array, got %v", err)
+	}
+}
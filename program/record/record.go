@@ -28,6 +28,13 @@ type Stream interface {
 	Next() (Record, error)
 }
 
+// RecordError is an error reading a single record from a Stream, after which the Stream may continue to be read (the
+// bad record having been skipped).
+type RecordError struct{ Err error }
+
+func (e RecordError) Error() string { return e.Err.Error() }
+func (e RecordError) Unwrap() error { return e.Err }
+
 // CollectStream buffers a Stream into an Array of records.
 func CollectStream(s Stream) (arr Array, err error) {
 	for {
@@ -0,0 +1,177 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// SpillOptions configures a SpillQueue.
+type SpillOptions struct {
+	Dir string // directory for the temporary spill file (if empty, os.TempDir())
+
+	// Marshal and Unmarshal encode Records to and from the spill file (if nil, as JSON). Marshal output must not
+	// contain newlines.
+	Marshal   func(rec Record) ([]byte, error)
+	Unmarshal func(data []byte) (Record, error)
+}
+
+// SpillQueue is an unbounded FIFO queue of Records (or RecordErrors), holding up to a limit in memory and spilling the
+// remainder to a temporary file. It is a Stream, returning queued Records in order until closed and drained.
+type SpillQueue struct {
+	opts     SpillOptions
+	memLimit int
+
+	mtx     sync.Mutex
+	cond    sync.Cond
+	mem     []RecordAndError // in-memory records, all preceding any spilled records
+	f       *os.File         // spill file, created on first spill
+	w       *bufio.Writer
+	r       *bufio.Reader
+	spilled int // records written to but not yet read from the spill file
+	closed  bool
+	err     error // if closed, error to return after draining (io.EOF if none)
+}
+
+func NewSpillQueue(memLimit int, opts SpillOptions) *SpillQueue {
+	if opts.Marshal == nil {
+		opts.Marshal = func(rec Record) ([]byte, error) { return json.Marshal(rec) }
+	}
+	if opts.Unmarshal == nil {
+		opts.Unmarshal = func(data []byte) (rec Record, err error) { return rec, json.Unmarshal(data, &rec) }
+	}
+	q := &SpillQueue{opts: opts, memLimit: memLimit}
+	q.cond.L = &q.mtx
+	return q
+}
+
+// Push adds rec to the queue. It must not be called after Close.
+func (q *SpillQueue) Push(rec Record) error {
+	return q.push(RecordAndError{Record: rec})
+}
+
+// PushError adds a RecordError to the queue, to be returned by Next in its place. If spilled, only the error message is
+// retained. It must not be called after Close.
+func (q *SpillQueue) PushError(err RecordError) error {
+	return q.push(RecordAndError{Err: err})
+}
+
+func (q *SpillQueue) push(re RecordAndError) (err error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.closed {
+		return errors.New("push to closed SpillQueue")
+	}
+	defer q.cond.Signal()
+
+	if q.spilled == 0 && len(q.mem) < q.memLimit {
+		q.mem = append(q.mem, re)
+		return nil
+	}
+
+	// Spill file lines are 'r' and a marshaled record, or 'e' and a JSON error message
+	var line []byte
+	if re.Err != nil {
+		line, err = json.Marshal(re.Err.Error())
+		line = append([]byte{'e'}, line...)
+	} else {
+		line, err = q.opts.Marshal(re.Record)
+		line = append([]byte{'r'}, line...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if q.f == nil {
+		if q.f, err = os.CreateTemp(q.opts.Dir, "hs-spill-*"); err != nil {
+			return err
+		}
+		q.w = bufio.NewWriter(q.f)
+		q.r = bufio.NewReader(io.NewSectionReader(q.f, 0, 1<<62)) // independent read offset
+	}
+	if _, err := q.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	q.spilled++
+	return nil
+}
+
+// Close marks the end of the queue. Once drained, Next returns err, or io.EOF if err is nil. Subsequent calls have no
+// effect.
+func (q *SpillQueue) Close(err error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.closed {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	q.closed, q.err = true, err
+	q.cond.Broadcast()
+}
+
+func (q *SpillQueue) Next() (Record, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for len(q.mem) == 0 && q.spilled == 0 {
+		if q.closed {
+			return nil, q.err
+		}
+		q.cond.Wait()
+	}
+
+	if len(q.mem) > 0 {
+		re := q.mem[0]
+		q.mem[0] = RecordAndError{} // release for GC
+		q.mem = q.mem[1:]
+		if re.Err != nil {
+			return nil, re.Err
+		}
+		return re.Record, nil
+	}
+
+	if err := q.w.Flush(); err != nil {
+		return nil, err
+	}
+	data, err := q.r.ReadBytes('\n')
+	if err == io.EOF { // stale EOF, from reaching the end of the file before more records were spilled
+		var rest []byte
+		rest, err = q.r.ReadBytes('\n')
+		data = append(data, rest...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	q.spilled--
+
+	data = data[:len(data)-1] // strip newline
+	if data[0] == 'e' {
+		var msg string
+		if err := json.Unmarshal(data[1:], &msg); err != nil {
+			return nil, err
+		}
+		return nil, RecordError{Err: errors.New(msg)}
+	}
+	return q.opts.Unmarshal(data[1:])
+}
+
+// Release deletes the spill file, if any. The queue must not be used afterward.
+func (q *SpillQueue) Release() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.f == nil {
+		return nil
+	}
+	_ = q.f.Close()
+	err := os.Remove(q.f.Name())
+	q.f = nil
+	return err
+}
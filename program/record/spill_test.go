@@ -0,0 +1,77 @@
+package record
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSpillQueue(t *testing.T) {
+	dir := t.TempDir()
+	q := NewSpillQueue(2, SpillOptions{Dir: dir})
+
+	var expect Array
+	push := func(recs ...Record) {
+		for _, rec := range recs {
+			if err := q.Push(rec); err != nil {
+				t.Fatalf("unexpected push error: %s", err)
+			}
+			expect = append(expect, rec)
+		}
+	}
+	pop := func(n int) (got Array) {
+		for i := 0; i < n; i++ {
+			rec, err := q.Next()
+			if err != nil {
+				t.Fatalf("unexpected next error: %s", err)
+			}
+			got = append(got, rec)
+		}
+		return got
+	}
+
+	push(1.0, "a", Object{"b": Array{true, nil}}, 4.0) // last two spill
+	got := pop(3)
+	push(5.0, 6.0) // spill, since spilled records remain
+	got = append(got, pop(2)...)
+	push(7.0) // in memory again
+	q.Close(nil)
+	got = append(got, pop(2)...)
+
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+	if _, err := q.Next(); err != io.EOF {
+		t.Errorf("got error %v after drain, expect EOF", err)
+	}
+
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("expected spill file in %s, found %d files", dir, len(ents))
+	} else if err := q.Release(); err != nil {
+		t.Errorf("unexpected release error: %s", err)
+	} else if ents, _ := os.ReadDir(dir); len(ents) != 0 {
+		t.Errorf("expected spill file removed from %s", dir)
+	}
+}
+
+func TestSpillQueueErrors(t *testing.T) {
+	q := NewSpillQueue(1, SpillOptions{Dir: t.TempDir()})
+	defer q.Release()
+
+	bad := errors.New("bad")
+	_ = q.Push(1.0)
+	_ = q.PushError(RecordError{Err: errors.New("bad record")}) // spilled
+	_ = q.Push(2.0)
+	q.Close(bad)
+	if rec, err := q.Next(); rec != 1.0 || err != nil {
+		t.Errorf("got %v, %v, expect 1, nil", rec, err)
+	} else if _, err := q.Next(); !errors.As(err, new(RecordError)) || err.Error() != "bad record" {
+		t.Errorf("got error %v, expect RecordError 'bad record'", err)
+	} else if rec, err := q.Next(); rec != 2.0 || err != nil {
+		t.Errorf("got %v, %v, expect 2, nil", rec, err)
+	} else if _, err := q.Next(); err != bad {
+		t.Errorf("got error %v, expect %v", err, bad)
+	}
+}
@@ -5,9 +5,20 @@ import (
 	"bytes"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
 )
 
 type RawStream struct {
@@ -27,68 +38,303 @@ func (r *RawStream) Next() (Record, error) {
 	return string(b), err
 }
 
+// PositionStream is a Stream that can report where in its input each Record begins.
+type PositionStream interface {
+	Stream
+	// NextPosition is as Next, but also returns the line number (1-based) in the input where the Record begins.
+	NextPosition() (rec Record, line int, err error)
+}
+
+// LineOptions configures a LineStream.
+type LineOptions struct {
+	Delim     string // record delimiter; if empty, newline (with any preceding carriage return also removed)
+	KeepEmpty bool   // if true, empty records produce empty strings, rather than being skipped
+}
+
 type LineStream struct {
-	r   bufio.Reader
-	mtx sync.Mutex
+	r     bufio.Reader
+	delim []byte
+	crlf  bool // if true, also strip a carriage return preceding the delimiter
+	keep  bool
+	mtx   sync.Mutex
+	line  int // newlines read so far
+}
+
+// NewLineStream creates a Stream producing a string for each delimited record of the input io.Reader (per opts). A final
+// record need not be followed by a delimiter.
+func NewLineStream(r io.Reader, opts LineOptions) *LineStream {
+	l := &LineStream{r: *bufio.NewReaderSize(r, 1<<10), delim: []byte(opts.Delim), keep: opts.KeepEmpty}
+	if len(l.delim) == 0 {
+		l.delim, l.crlf = []byte("\n"), true
+	}
+	return l
 }
 
-func NewLineStream(r io.Reader) *LineStream {
-	return &LineStream{r: *bufio.NewReaderSize(r, 1<<10)}
+func (l *LineStream) Next() (Record, error) {
+	rec, _, err := l.NextPosition()
+	return rec, err
 }
 
-func (l *LineStream) Next() (out Record, err error) {
+func (l *LineStream) NextPosition() (out Record, line int, err error) {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
 
-	var buf bytes.Buffer
 	for {
-		data, more, err := l.r.ReadLine()
+		data, err := l.readRecord()
 		if err != nil {
-			return nil, err // handles io.EOF too
-		} else if len(data) == 0 && !more {
-			continue // ignore empty lines
+			return nil, 0, err // handles io.EOF too
 		}
+		line = l.line + 1
+		l.line += bytes.Count(data, []byte("\n"))
 
-		if buf.Len() == 0 {
-			if more {
-				buf = *bytes.NewBuffer(data)
-			} else if len(data) > 0 { // only return non-empty lines
-				return string(data), nil
-			}
-		} else {
-			_, _ = buf.Write(data)
-			if !more {
-				out = string(buf.Bytes())
-				buf.Reset()
-				return out, nil
-			}
+		data = bytes.TrimSuffix(data, l.delim)
+		if l.crlf {
+			data = bytes.TrimSuffix(data, []byte("\r"))
+		}
+		if len(data) > 0 || l.keep {
+			return string(data), line, nil
 		}
 	}
 }
 
+// readRecord reads through the next delimiter (inclusive), or to the end of input if there is none.
+func (l *LineStream) readRecord() (data []byte, err error) {
+	last := l.delim[len(l.delim)-1]
+	for {
+		chunk, err := l.r.ReadBytes(last)
+		data = append(data, chunk...)
+		if err == io.EOF && len(data) > 0 {
+			return data, nil // final record, with no delimiter
+		} else if err != nil {
+			return nil, err
+		} else if bytes.HasSuffix(data, l.delim) {
+			return data, nil
+		}
+	}
+}
+
+// JSONStream parses a stream of JSON values. On a syntax error, it returns a RecordError and resumes after the bad value
+// (see resync).
 type JSONStream struct {
-	d   json.Decoder
-	mtx sync.Mutex
+	d     json.Decoder
+	r     io.Reader // underlying reader of d
+	lines *lineTracker
+	base  int64 // offset in lines at which d began reading
+	mtx   sync.Mutex
 }
 
 func NewJSONStream(r io.Reader) *JSONStream {
-	return &JSONStream{d: *json.NewDecoder(r)}
+	lines := newLineTracker(r)
+	return &JSONStream{d: *json.NewDecoder(lines), r: lines, lines: lines}
 }
 
 func (j *JSONStream) Next() (out Record, err error) {
 	j.mtx.Lock()
 	defer j.mtx.Unlock()
-	err = j.d.Decode(&out)
+	if err = j.decode(&out); err == nil {
+		j.lines.lineAt(j.base + j.d.InputOffset()) // discard newline positions no longer needed
+	}
 	return
 }
 
+func (j *JSONStream) NextPosition() (out Record, line int, err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	var raw json.RawMessage
+	if err := j.decode(&raw); err != nil {
+		return nil, 0, err // handles io.EOF too
+	}
+	line = j.lines.lineAt(j.base + j.d.InputOffset() - int64(len(raw)))
+	err = json.Unmarshal(raw, &out)
+	return out, line, err
+}
+
+func (j *JSONStream) decode(v any) error {
+	err := j.d.Decode(v)
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return err
+	}
+
+	badStart, rerr := j.resync()
+	if rerr != nil {
+		return rerr
+	}
+	return RecordError{Err: fmt.Errorf("line %d: %w", j.lines.lineAt(badStart), err)}
+}
+
+// resync recovers from a syntax error in d, by discarding the bad value, and resuming decoding after it. It returns the
+// offset at which the bad value begins. An array or object is discarded through its closing bracket, matching brackets
+// outside strings (a closing bracket also closes any unclosed brackets within it), or else to the end of input; any
+// other value is discarded through the end of its line.
+func (j *JSONStream) resync() (badStart int64, err error) {
+	off := j.base + j.d.InputOffset() // d stops at the beginning of (whitespace before) the bad value
+	r := io.MultiReader(j.d.Buffered(), j.r)
+
+	badStart = -1
+	var open []byte // unclosed brackets of the bad value
+	var inString, escaped bool
+	for b := make([]byte, 1); ; off++ {
+		if _, err := io.ReadFull(r, b); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		c := b[0]
+
+		if badStart == -1 {
+			if unicode.IsSpace(rune(c)) {
+				continue
+			}
+			badStart = off
+		}
+
+		if len(open) == 0 && badStart != off { // not in an array or object
+			if c == '\n' {
+				off++
+				break
+			}
+			continue
+		}
+
+		switch {
+		case inString && escaped:
+			escaped = false
+		case inString:
+			escaped, inString = c == '\\', c != '"' && c != '\n' // (a newline can't be in a string; assume it was unclosed)
+		case c == '"':
+			inString = true
+		case c == '[' || c == '{':
+			open = append(open, c)
+		case c == ']' || c == '}':
+			opener := byte('[')
+			if c == '}' {
+				opener = '{'
+			}
+			if i := bytes.LastIndexByte(open, opener); i >= 0 {
+				open = open[:i]
+			}
+			if len(open) == 0 {
+				off++
+				j.d, j.r, j.base = *json.NewDecoder(r), r, off
+				return badStart, nil
+			}
+		}
+	}
+	if badStart == -1 {
+		badStart = off
+	}
+
+	j.d, j.r, j.base = *json.NewDecoder(r), r, off
+	return badStart, nil
+}
+
+// lineTracker wraps an io.Reader, tracking newlines read so that byte offsets may be converted to line numbers.
+type lineTracker struct {
+	r        io.Reader
+	read     int64   // bytes read so far
+	newlines []int64 // offsets of newlines not yet passed by lineAt
+	line     int     // line number at the offset last passed to lineAt
+}
+
+func newLineTracker(r io.Reader) *lineTracker { return &lineTracker{r: r, line: 1} }
+
+func (t *lineTracker) Read(p []byte) (n int, err error) {
+	n, err = t.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			t.newlines = append(t.newlines, t.read+int64(i))
+		}
+	}
+	t.read += int64(n)
+	return n, err
+}
+
+// lineAt returns the line number (1-based) of byte offset off. off must not be less than in any prior call.
+func (t *lineTracker) lineAt(off int64) int {
+	i := 0
+	for i < len(t.newlines) && t.newlines[i] < off {
+		i++
+	}
+	t.newlines, t.line = t.newlines[i:], t.line+i
+	return t.line
+}
+
+// YAMLStream parses a stream of YAML documents (separated by "---"), one Record per document.
+type YAMLStream struct {
+	d   yaml.Decoder
+	mtx sync.Mutex
+}
+
+func NewYAMLStream(r io.Reader) *YAMLStream {
+	return &YAMLStream{d: *yaml.NewDecoder(r)}
+}
+
+func (y *YAMLStream) Next() (Record, error) {
+	rec, _, err := y.NextPosition()
+	return rec, err
+}
+
+func (y *YAMLStream) NextPosition() (rec Record, line int, err error) {
+	y.mtx.Lock()
+	defer y.mtx.Unlock()
+
+	var doc yaml.Node
+	if err := y.d.Decode(&doc); err != nil {
+		return nil, 0, err // handles io.EOF too
+	}
+	line = doc.Line
+	if len(doc.Content) > 0 {
+		line = doc.Content[0].Line // line of the value, rather than of any document separator before it
+	}
+
+	yamlTimestampsAsText(&doc)
+	var raw any
+	if err := doc.Decode(&raw); err != nil {
+		return nil, 0, err
+	}
+	rec, err = Normalize(raw)
+	return rec, line, err
+}
+
+// yamlTimestampsAsText retags the timestamp scalars (e.g. unquoted dates) under n as strings, so they decode as written
+// rather than as time.Time.
+func yamlTimestampsAsText(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!timestamp" {
+		n.Tag = "!!str"
+	}
+	for _, child := range n.Content {
+		yamlTimestampsAsText(child)
+	}
+}
+
 type CsvStream struct {
 	r   csv.Reader
 	mtx sync.Mutex
 
 	raw        bool
 	concurrent bool
+	opts       CsvOptions
 	fields     []string
+	cols       []csvColumn // per-column conversion, for header fields (if !raw)
+	checked    bool        // column numbers in opts have been checked (if raw)
+}
+
+// CsvOptions are optional settings for CsvStream. Columns are identified by header name, or by 1-based column number
+// (as a string) if raw.
+type CsvOptions struct {
+	Types  map[string]CsvType // column types; columns not listed are CsvString (or CsvAuto, if Infer)
+	Infer  bool               // infer types (as CsvAuto) for columns not in Types
+	Rename map[string]string  // header name -> field name (ignored if raw)
+	Skip   []string           // columns to omit (ignored if raw)
+	Ragged bool               // allow rows with more or fewer fields than the header; missing fields are null, and extra fields are dropped
+}
+
+type csvColumn struct {
+	name string
+	typ  CsvType
+	skip bool
 }
 
 // NewCsvReader creates a Stream by parsing the input io.Reader as comma-separated value format.
@@ -96,20 +342,66 @@ type CsvStream struct {
 // If raw, no CSV header is expected, and each line becomes a simple Array from field values; otherwise, the first line
 // is interpreted as a header defining field names, and each line becomes an Object using those field names.
 // Next is safe for concurrent use by multiple goroutines iff concurrent is true.
-func NewCsvReader(r io.Reader, comma rune, raw bool, concurrent bool) *CsvStream {
-	out := &CsvStream{r: *csv.NewReader(r), raw: raw}
+func NewCsvReader(r io.Reader, comma rune, raw bool, concurrent bool, opts CsvOptions) *CsvStream {
+	out := &CsvStream{r: *csv.NewReader(r), raw: raw, opts: opts}
 	out.r.Comma = comma
 	out.r.ReuseRecord = !concurrent // lets csv.Reader recycle Read's return slice
+	if opts.Ragged {
+		out.r.FieldsPerRecord = -1
+	}
 	return out
 }
 
 func (c *CsvStream) loadHeader() error {
 	hdr, err := c.r.Read()
+	if err != nil {
+		return err
+	}
+
 	c.fields = append([]string{}, hdr...) // copy due to c.r.ReuseRecord
-	return err
+	c.cols = make([]csvColumn, len(c.fields))
+	known := make(map[string]bool, len(c.fields))
+	for i, field := range c.fields {
+		known[field] = true
+		c.cols[i] = csvColumn{name: field, typ: c.opts.columnType(field)}
+		if newName, ok := c.opts.Rename[field]; ok {
+			c.cols[i].name = newName
+		}
+		c.cols[i].skip = slices.Contains(c.opts.Skip, field)
+	}
+
+	// Check for unknown columns, to catch typos
+	for _, names := range [][]string{slices.Collect(maps.Keys(c.opts.Types)), slices.Collect(maps.Keys(c.opts.Rename)), c.opts.Skip} {
+		for _, name := range names {
+			if !known[name] {
+				return fmt.Errorf("CSV header has no column '%s'", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkColumnNumbers checks that the columns in opts are valid column numbers, as required if raw, to catch typos.
+func (c *CsvStream) checkColumnNumbers() error {
+	for name := range c.opts.Types {
+		if n, err := strconv.Atoi(name); err != nil || n < 1 || strconv.Itoa(n) != name {
+			return fmt.Errorf("bad CSV column '%s', should be a column number (from 1)", name)
+		}
+	}
+	c.checked = true
+	return nil
+}
+
+func (o CsvOptions) columnType(name string) CsvType {
+	if t, ok := o.Types[name]; ok {
+		return t
+	} else if o.Infer {
+		return CsvAuto
+	}
+	return CsvString
 }
 
-func (c *CsvStream) nextVals() ([]string, error) {
+func (c *CsvStream) nextVals() (vals []string, line int, err error) {
 	if c.concurrent {
 		c.mtx.Lock()
 		defer c.mtx.Unlock()
@@ -117,30 +409,125 @@ func (c *CsvStream) nextVals() ([]string, error) {
 
 	if !c.raw && c.fields == nil {
 		if err := c.loadHeader(); err != nil {
-			return nil, err
+			return nil, 0, err
+		}
+	} else if c.raw && !c.checked {
+		if err := c.checkColumnNumbers(); err != nil {
+			return nil, 0, err
 		}
 	}
 
-	return c.r.Read() // c.concurrent -> !c.r.ReuseRecord -> fresh memory, making the return safe for concurrent in that case
+	vals, err = c.r.Read() // c.concurrent -> !c.r.ReuseRecord -> fresh memory, making the return safe for concurrent in that case
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, 0, RecordError{Err: err} // csv.Reader resumes after the bad record
+	} else if err != nil {
+		return nil, 0, err
+	}
+	line, _ = c.r.FieldPos(0)
+	return vals, line, nil
 }
 
 func (c *CsvStream) Next() (Record, error) {
-	vals, err := c.nextVals()
+	rec, _, err := c.NextPosition()
+	return rec, err
+}
+
+func (c *CsvStream) NextPosition() (Record, int, error) {
+	vals, line, err := c.nextVals()
+	if err != nil {
+		return nil, 0, err // handles io.EOF too
+	}
+	rec, err := c.convert(vals)
 	if err != nil {
-		return nil, err // handles io.EOF too
+		return nil, 0, RecordError{Err: fmt.Errorf("line %d: %w", line, err)}
 	}
+	return rec, line, nil
+}
+
+func (c *CsvStream) convert(vals []string) (_ Record, err error) {
 
 	if c.raw {
 		out := make(Array, len(vals))
 		for i, v := range vals {
-			out[i] = v
+			colNum := strconv.Itoa(i + 1)
+			if out[i], err = c.opts.columnType(colNum).Convert(v); err != nil {
+				return nil, fmt.Errorf("CSV column %s: %w", colNum, err)
+			}
 		}
 		return out, nil
 	} else {
-		out := make(Object, len(vals))
-		for i, v := range vals {
-			out[c.fields[i]] = v
+		out := make(Object, len(c.cols))
+		for i, col := range c.cols {
+			if col.skip {
+				continue
+			} else if i >= len(vals) { // only if c.opts.Ragged
+				out[col.name] = nil
+			} else if out[col.name], err = col.typ.Convert(vals[i]); err != nil {
+				return nil, fmt.Errorf("CSV column '%s': %w", c.fields[i], err)
+			}
 		}
 		return out, nil
 	}
 }
+
+// CsvType is a type to which CSV values in a column are converted.
+type CsvType int
+
+const (
+	CsvString = CsvType(iota)
+	CsvNumber
+	CsvInt
+	CsvBool
+	CsvAuto // infer each value: empty or "null" as null, "true"/"false" as bool, a JSON number as number, else string
+)
+
+var csvTypeNames = [...]string{CsvString: "string", CsvNumber: "number", CsvInt: "int", CsvBool: "bool", CsvAuto: "auto"}
+
+func (t CsvType) String() string { return csvTypeNames[t] }
+
+// ParseCsvType returns the CsvType with the given name (one of: string, number, int, bool, auto).
+func ParseCsvType(name string) (CsvType, error) {
+	if i := slices.Index(csvTypeNames[:], name); i != -1 {
+		return CsvType(i), nil
+	}
+	return 0, fmt.Errorf("unknown CSV type '%s' (expected one of: %s)", name, strings.Join(csvTypeNames[:], " "))
+}
+
+var jsonNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// Convert converts a CSV value to type t. For all types except CsvString, an empty value is converted to null.
+func (t CsvType) Convert(s string) (Record, error) {
+	if s == "" && t != CsvString {
+		return nil, nil
+	}
+
+	switch t {
+	case CsvNumber, CsvInt:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("bad number '%s'", s)
+		} else if t == CsvInt && f != math.Trunc(f) {
+			return nil, fmt.Errorf("bad integer '%s'", s)
+		}
+		return f, nil
+	case CsvBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad bool '%s'", s)
+		}
+		return b, nil
+	case CsvAuto:
+		switch lower := strings.ToLower(s); {
+		case lower == "null":
+			return nil, nil
+		case lower == "true" || lower == "false":
+			return lower == "true", nil
+		case jsonNumberRegexp.MatchString(s):
+			if f, err := CsvNumber.Convert(s); err == nil { // else, out of range; leave as string
+				return f, nil
+			}
+		}
+	}
+	return s, nil
+}
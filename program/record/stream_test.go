@@ -0,0 +1,277 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCsvStreamOptions(t *testing.T) {
+	const input = "id,active,name,zip,junk\n1,true,a,007,x\n2,,b,1e3\n"
+	opts := CsvOptions{
+		Types:  map[string]CsvType{"id": CsvInt, "active": CsvBool},
+		Infer:  true,
+		Rename: map[string]string{"name": "nm"},
+		Skip:   []string{"junk"},
+		Ragged: true,
+	}
+
+	got, err := CollectStream(NewCsvReader(strings.NewReader(input), ',', false, false, opts))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expect := Array{
+		Object{"id": 1.0, "active": true, "nm": "a", "zip": "007"},
+		Object{"id": 2.0, "active": nil, "nm": "b", "zip": 1000.0},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+}
+
+func TestCsvStreamRawColumns(t *testing.T) {
+	const input = "1,true,x\n2,,y\n"
+	tests := []struct {
+		Types   map[string]CsvType
+		Expect  Array
+		WantErr bool
+	}{
+		{Types: map[string]CsvType{"1": CsvInt, "2": CsvBool}, Expect: Array{Array{1.0, true, "x"}, Array{2.0, nil, "y"}}},
+		{Types: map[string]CsvType{"4": CsvInt}, Expect: Array{Array{"1", "true", "x"}, Array{"2", "", "y"}}}, // rows may be ragged
+		{Types: map[string]CsvType{"0": CsvInt}, WantErr: true},
+		{Types: map[string]CsvType{"01": CsvInt}, WantErr: true},
+		{Types: map[string]CsvType{"id": CsvInt}, WantErr: true},
+	}
+	for _, tst := range tests {
+		got, err := CollectStream(NewCsvReader(strings.NewReader(input), ',', true, false, CsvOptions{Types: tst.Types}))
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%v': got error %v, expect error %v", tst.Types, err, tst.WantErr)
+		} else if !tst.WantErr && !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%v': got %v, expect %v", tst.Types, got, tst.Expect)
+		}
+	}
+}
+
+func TestCsvTypeConvert(t *testing.T) {
+	tests := []struct {
+		Type    CsvType
+		Input   string
+		Expect  Record
+		WantErr bool
+	}{
+		{Type: CsvString, Input: "", Expect: ""},
+		{Type: CsvNumber, Input: "1.5", Expect: 1.5},
+		{Type: CsvNumber, Input: "", Expect: nil},
+		{Type: CsvNumber, Input: "inf", WantErr: true},
+		{Type: CsvInt, Input: "1.5", WantErr: true},
+		{Type: CsvBool, Input: "TRUE", Expect: true},
+		{Type: CsvBool, Input: "yes", WantErr: true},
+		{Type: CsvAuto, Input: "Null", Expect: nil},
+		{Type: CsvAuto, Input: "false", Expect: false},
+		{Type: CsvAuto, Input: "-12.5e1", Expect: -125.0},
+		{Type: CsvAuto, Input: "0012", Expect: "0012"},
+		{Type: CsvAuto, Input: "1e999", Expect: "1e999"},
+	}
+
+	for _, tst := range tests {
+		got, err := tst.Type.Convert(tst.Input)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("%s '%s': got error %v, expect error %v", tst.Type, tst.Input, err, tst.WantErr)
+		} else if !tst.WantErr && got != tst.Expect {
+			t.Errorf("%s '%s': got %v, expect %v", tst.Type, tst.Input, got, tst.Expect)
+		}
+	}
+}
+
+func TestYAMLStream(t *testing.T) {
+	const input = `name: a
+count: 3
+tags: [x, "y", 1.5]
+---
+# non-string keys, converted to strings
+1: one
+true: yes
+2.5: {nested: {3: three}}
+null: nil
+---
+- 2024-01-02
+- 2001-12-14t21:59:43.10-05:00
+- ~
+- "007"
+...
+--- plain
+`
+	got, err := CollectStream(NewYAMLStream(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expect := Array{
+		Object{"name": "a", "count": 3.0, "tags": Array{"x", "y", 1.5}},
+		Object{"1": "one", "true": "yes", "2.5": Object{"nested": Object{"3": "three"}}, "null": "nil"},
+		Array{"2024-01-02", "2001-12-14t21:59:43.10-05:00", nil, "007"},
+		"plain",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+
+	if _, err := CollectStream(NewYAMLStream(strings.NewReader("a: 1\n---\nb: [\n"))); err == nil {
+		t.Errorf("expected error for malformed document")
+	}
+}
+
+func TestStreamPositions(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Stream PositionStream
+		Expect []int
+	}{
+		{Name: "lines", Stream: NewLineStream(strings.NewReader("a\n\nb\nc"), LineOptions{}), Expect: []int{1, 3, 4}},
+		{Name: "json", Stream: NewJSONStream(strings.NewReader("{\"a\":\n1}\n\n[1,\n2] 3\n\"x\"")), Expect: []int{1, 4, 5, 6}},
+		{Name: "yaml", Stream: NewYAMLStream(strings.NewReader("a: 1\n---\n\nb: 2\n")), Expect: []int{1, 4}},
+		{Name: "xml", Stream: mustXMLStream(t, "<r>\n<i/>\n\n<i>x</i></r>"), Expect: []int{2, 4}},
+		{Name: "csv", Stream: NewCsvReader(strings.NewReader("h\n1\n\"2\n\"\n3\n"), ',', false, false, CsvOptions{}), Expect: []int{2, 3, 5}},
+	}
+
+	for _, tst := range tests {
+		var got []int
+		for {
+			_, line, err := tst.Stream.NextPosition()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("test '%s': unexpected error: %s", tst.Name, err)
+			}
+			got = append(got, line)
+		}
+		if !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s' failed: got lines %v, expect %v", tst.Name, got, tst.Expect)
+		}
+	}
+}
+
+func mustXMLStream(t *testing.T, doc string) *XMLStream {
+	s, err := NewXMLStream(strings.NewReader(doc), DefaultXMLRecordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJSONEachStream(t *testing.T) {
+	const input = `{"meta":{"items":[0]},"items":[{"a":1},` + "\n" + `[2,3],"x"],"more":{"items":[]}}` + "\n" +
+		`{"items":{"k1":4,"k2":[5]}} {"items":6} [7]`
+
+	tests := []struct {
+		Path        string
+		Expect      Array
+		ExpectLines []int
+	}{
+		{Path: ".items[]", Expect: Array{Object{"a": 1.0}, Array{2.0, 3.0}, "x", 4.0, Array{5.0}}, ExpectLines: []int{1, 2, 2, 3, 3}},
+		{Path: `.["items"][1]`, Expect: Array{Array{2.0, 3.0}}, ExpectLines: []int{2}},
+		{Path: ".items[][]", Expect: Array{1.0, 2.0, 3.0, 5.0}, ExpectLines: []int{1, 2, 2, 3}},
+		{Path: ".meta.items[0]", Expect: Array{0.0}, ExpectLines: []int{1}},
+		{Path: ".[][0]", Expect: Array{Object{"a": 1.0}}, ExpectLines: []int{1}},
+		{Path: ".", Expect: Array{
+			Object{"meta": Object{"items": Array{0.0}}, "items": Array{Object{"a": 1.0}, Array{2.0, 3.0}, "x"}, "more": Object{"items": Array{}}},
+			Object{"items": Object{"k1": 4.0, "k2": Array{5.0}}}, Object{"items": 6.0}, Array{7.0},
+		}, ExpectLines: []int{1, 3, 3, 3}},
+	}
+
+	for _, tst := range tests {
+		s, err := NewJSONEachStream(strings.NewReader(input), tst.Path)
+		if err != nil {
+			t.Fatalf("test '%s': unexpected error: %s", tst.Path, err)
+		}
+		var got Array
+		var gotLines []int
+		for {
+			rec, line, err := s.NextPosition()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("test '%s': unexpected error: %s", tst.Path, err)
+			}
+			got, gotLines = append(got, rec), append(gotLines, line)
+		}
+		if !reflect.DeepEqual(got, tst.Expect) || !reflect.DeepEqual(gotLines, tst.ExpectLines) {
+			t.Errorf("test '%s' failed: got %v (lines %v), expect %v (lines %v)", tst.Path, got, gotLines, tst.Expect, tst.ExpectLines)
+		}
+	}
+}
+
+func TestJSONEachStreamErrors(t *testing.T) {
+	for _, path := range []string{"", "items", ".a..b", ".a[", ".a[x]", ".a[-1]", `.["a]`} {
+		if _, err := NewJSONEachStream(strings.NewReader(""), path); err == nil {
+			t.Errorf("path '%s': expected error", path)
+		}
+	}
+
+	s, err := NewJSONEachStream(strings.NewReader(`{"items":[1,`), ".items[]")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := CollectStream(s); err == nil || err == io.EOF {
+		t.Errorf("truncated input: got error %v, expect a syntax error", err)
+	}
+}
+
+func TestLineStream(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Input  string
+		Opts   LineOptions
+		Expect Array
+	}{
+		{Name: "newlines", Input: "a\r\n\nb\nc", Expect: Array{"a", "b", "c"}},
+		{Name: "newlines, keep empty", Input: "a\r\n\nb\n", Opts: LineOptions{KeepEmpty: true}, Expect: Array{"a", "", "b"}},
+		{Name: "NUL", Input: "a\nb\x00\x00c\x00", Opts: LineOptions{Delim: "\x00"}, Expect: Array{"a\nb", "c"}},
+		{Name: "NUL, keep empty", Input: "a\x00\x00c", Opts: LineOptions{Delim: "\x00", KeepEmpty: true}, Expect: Array{"a", "", "c"}},
+		{Name: "multi-byte", Input: "a--b-c----d--", Opts: LineOptions{Delim: "--"}, Expect: Array{"a", "b-c", "d"}},
+		{Name: "long", Input: strings.Repeat("x", 5000) + "\ny", Expect: Array{strings.Repeat("x", 5000), "y"}},
+	}
+
+	for _, tst := range tests {
+		got, err := CollectStream(NewLineStream(strings.NewReader(tst.Input), tst.Opts))
+		if err != nil {
+			t.Errorf("test '%s' failed: unexpected error: %s", tst.Name, err)
+		} else if !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s' failed: got %q, expect %q", tst.Name, got, tst.Expect)
+		}
+	}
+}
+
+func TestJSONStreamResync(t *testing.T) {
+	const input = "{\"a\":1}\n  {bad}\n{\"a\":2} [\n3,\n{oops\n]\n\"x\"\n" +
+		"[{\"s\":\"]\\\"[\"}, oops] 4\ntru\n5\n"
+	s := NewJSONStream(strings.NewReader(input))
+
+	var got Array
+	var gotLines, errLines []int
+	for {
+		rec, line, err := s.NextPosition()
+		var recErr RecordError
+		if err == io.EOF {
+			break
+		} else if errors.As(err, &recErr) {
+			var n int
+			_, _ = fmt.Sscanf(err.Error(), "line %d:", &n)
+			errLines = append(errLines, n)
+			continue
+		} else if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got, gotLines = append(got, rec), append(gotLines, line)
+	}
+
+	// Bad arrays and objects are skipped through their closing bracket (ignoring brackets in strings, and closing the
+	// unclosed "{oops"), and other bad values through the end of the line
+	expect, expectLines := Array{Object{"a": 1.0}, Object{"a": 2.0}, "x", 4.0, 5.0}, []int{1, 3, 7, 8, 10}
+	expectErrLines := []int{2, 3, 8, 9}
+	if !reflect.DeepEqual(got, expect) || !reflect.DeepEqual(gotLines, expectLines) || !reflect.DeepEqual(errLines, expectErrLines) {
+		t.Errorf("got %v (lines %v, errors at lines %v), expect %v (lines %v, errors at lines %v)", got, gotLines, errLines, expect, expectLines, expectErrLines)
+	}
+}
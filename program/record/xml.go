@@ -0,0 +1,200 @@
+package record
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	xmlAttrsKey = "@"
+	xmlTextKey  = "#text"
+
+	DefaultXMLRecordPath = "/*/*" // children of the root element
+)
+
+// XMLStream parses an XML document, producing one Object per element matching a path (see NewXMLStream).
+type XMLStream struct {
+	d    xml.Decoder
+	path xmlPath
+	mtx  sync.Mutex
+
+	stack []string // names of currently open elements
+}
+
+// NewXMLStream creates a Stream by parsing the input io.Reader as XML, producing an Object (as by ParseXML) for each
+// element matching recordPath. recordPath is a simple XPath-like expression: a sequence of element names (or '*' for any
+// name), each preceded by '/' (child of the previous element, or root for the first) or '//' (descendant at any depth).
+// Examples: "/*/*" (children of the root element), "//item" (all item elements), "/feed/entry".
+// Matching elements nested inside a matched element are not separately produced.
+func NewXMLStream(r io.Reader, recordPath string) (*XMLStream, error) {
+	path, err := parseXMLPath(recordPath)
+	if err != nil {
+		return nil, err
+	}
+	return &XMLStream{d: *xml.NewDecoder(r), path: path}, nil
+}
+
+func (x *XMLStream) Next() (Record, error) {
+	rec, _, err := x.NextPosition()
+	return rec, err
+}
+
+func (x *XMLStream) NextPosition() (Record, int, error) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	for {
+		tok, err := x.d.Token()
+		if err != nil {
+			return nil, 0, err // handles io.EOF too
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			x.stack = append(x.stack, tok.Name.Local)
+			if x.path.match(x.stack) {
+				x.stack = x.stack[:len(x.stack)-1] // decodeXMLElement consumes the end element
+				line, _ := x.d.InputPos()
+				rec, err := decodeXMLElement(&x.d, tok)
+				return rec, line, err
+			}
+		case xml.EndElement:
+			x.stack = x.stack[:len(x.stack)-1]
+		}
+	}
+}
+
+// ParseXML parses an XML document, returning its root element as an Object:
+//   - attributes are stored in an Object under key "@" (if any)
+//   - non-whitespace character data is concatenated and stored under key "#text" (if any)
+//   - child elements are stored under their names; a child appearing more than once becomes an Array. A child with
+//     no attributes or child elements is stored as just its character data (a string).
+//
+// Namespaces are ignored (only local names are used).
+func ParseXML(doc string) (Object, error) {
+	d := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no root element in XML document")
+		} else if err != nil {
+			return nil, err
+		} else if start, ok := tok.(xml.StartElement); ok {
+			return decodeXMLElement(d, start)
+		}
+	}
+}
+
+// decodeXMLElement decodes the element begun by start, consuming all tokens through its end element.
+func decodeXMLElement(d *xml.Decoder, start xml.StartElement) (Object, error) {
+	out := make(Object)
+	if len(start.Attr) > 0 {
+		attrs := make(Object, len(start.Attr))
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		out[xmlAttrsKey] = attrs
+	}
+
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(d, tok)
+			if err != nil {
+				return nil, err
+			}
+			addXMLChild(out, tok.Name.Local, simplifyXMLElement(child))
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if s := strings.TrimSpace(text.String()); s != "" {
+				out[xmlTextKey] = s
+			}
+			return out, nil
+		}
+	}
+}
+
+// addXMLChild adds child under name in parent, converting to an Array on repeated names.
+func addXMLChild(parent Object, name string, child Record) {
+	if existing, ok := parent[name]; !ok {
+		parent[name] = child
+	} else if arr, ok := existing.(Array); ok { // child values are never themselves Arrays
+		parent[name] = append(arr, child)
+	} else {
+		parent[name] = Array{existing, child}
+	}
+}
+
+// simplifyXMLElement returns the character data of an element with no attributes or child elements, or the element
+// itself otherwise.
+func simplifyXMLElement(elem Object) Record {
+	if text, ok := elem[xmlTextKey]; ok && len(elem) == 1 {
+		return text
+	} else if len(elem) == 0 {
+		return ""
+	}
+	return elem
+}
+
+type xmlPathStep struct {
+	name       string // "*" matches any name
+	descendant bool   // if true, may match at any depth below the previous step
+}
+
+type xmlPath []xmlPathStep
+
+func parseXMLPath(src string) (path xmlPath, err error) {
+	rest := src
+	for rest != "" {
+		var step xmlPathStep
+		if strings.HasPrefix(rest, "//") {
+			step.descendant, rest = true, rest[2:]
+		} else if strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+		} else {
+			return nil, fmt.Errorf("bad XML record path '%s': expected '/' or '//' before each element name", src)
+		}
+
+		step.name, rest, _ = strings.Cut(rest, "/")
+		if rest != "" {
+			rest = "/" + rest
+		}
+		if step.name == "" {
+			return nil, fmt.Errorf("bad XML record path '%s': empty element name", src)
+		}
+		path = append(path, step)
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty XML record path")
+	}
+	return path, nil
+}
+
+// match returns true if the element with ancestry stack (element names, from root to the element itself) matches p.
+func (p xmlPath) match(stack []string) bool {
+	if len(p) == 0 {
+		return len(stack) == 0
+	}
+
+	step := p[0]
+	for i := range stack {
+		if (step.name == "*" || step.name == stack[i]) && p[1:].match(stack[i+1:]) {
+			return true
+		} else if !step.descendant {
+			break
+		}
+	}
+	return false
+}
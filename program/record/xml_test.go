@@ -0,0 +1,125 @@
+package record
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseXML(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Input   string
+		Expect  Object
+		WantErr bool
+	}{
+		{
+			Name:   "attributes and text",
+			Input:  `<?xml version="1.0"?><a id="1" ns:k="v"> hi <b/> there </a>`,
+			Expect: Object{"@": Object{"id": "1", "k": "v"}, "#text": "hi  there", "b": ""},
+		},
+		{
+			Name:  "repeated children",
+			Input: "<list>\n  <item>x</item>\n  <item n=\"2\">y</item>\n  <item><c>z</c></item>\n  <other>w</other>\n</list>",
+			Expect: Object{
+				"item":  Array{"x", Object{"@": Object{"n": "2"}, "#text": "y"}, Object{"c": "z"}},
+				"other": "w",
+			},
+		},
+		{Name: "empty root", Input: `<a/>`, Expect: Object{}},
+		{Name: "no root", Input: `<!-- nothing -->`, WantErr: true},
+		{Name: "unclosed", Input: `<a><b>x</b>`, WantErr: true},
+		{Name: "mismatched", Input: `<a><b>x</c></a>`, WantErr: true},
+	}
+	for _, tst := range tests {
+		got, err := ParseXML(tst.Input)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%s': unexpected error: %v", tst.Name, err)
+		} else if !tst.WantErr && !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': got %v, expect %v", tst.Name, got, tst.Expect)
+		}
+	}
+}
+
+func TestXMLStream(t *testing.T) {
+	const input = `<feed>
+  <title>t</title>
+  <entry id="1"><title>a</title></entry>
+  <group>
+    <entry id="2"><title>b</title><entry id="3"/></entry>
+  </group>
+</feed>`
+	tests := []struct {
+		Path        string
+		Expect      Array
+		ExpectLines []int
+		WantErr     bool
+	}{
+		{
+			Path:        DefaultXMLRecordPath,
+			Expect:      Array{Object{"#text": "t"}, Object{"@": Object{"id": "1"}, "title": "a"}, Object{"entry": Object{"@": Object{"id": "2"}, "title": "b", "entry": Object{"@": Object{"id": "3"}}}}},
+			ExpectLines: []int{2, 3, 4},
+		},
+		{
+			Path:        "/feed/entry",
+			Expect:      Array{Object{"@": Object{"id": "1"}, "title": "a"}},
+			ExpectLines: []int{3},
+		},
+		{
+			Path:        "//entry", // nested matches are not separately produced
+			Expect:      Array{Object{"@": Object{"id": "1"}, "title": "a"}, Object{"@": Object{"id": "2"}, "title": "b", "entry": Object{"@": Object{"id": "3"}}}},
+			ExpectLines: []int{3, 5},
+		},
+		{
+			Path:        "/*//title",
+			Expect:      Array{Object{"#text": "t"}, Object{"#text": "a"}, Object{"#text": "b"}},
+			ExpectLines: []int{2, 3, 5},
+		},
+		{Path: "/nothing", Expect: nil},
+		{Path: "feed", WantErr: true},
+		{Path: "/feed//", WantErr: true},
+		{Path: "", WantErr: true},
+	}
+	for _, tst := range tests {
+		x, err := NewXMLStream(strings.NewReader(input), tst.Path)
+		if (err != nil) != tst.WantErr {
+			t.Errorf("test '%s': unexpected error: %v", tst.Path, err)
+			continue
+		} else if tst.WantErr {
+			continue
+		}
+
+		var got Array
+		var gotLines []int
+		for {
+			rec, line, err := x.NextPosition()
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("test '%s': unexpected error: %v", tst.Path, err)
+				}
+				break
+			}
+			got, gotLines = append(got, rec), append(gotLines, line)
+		}
+		if !reflect.DeepEqual(got, tst.Expect) {
+			t.Errorf("test '%s': got %v, expect %v", tst.Path, got, tst.Expect)
+		}
+		if !reflect.DeepEqual(gotLines, tst.ExpectLines) {
+			t.Errorf("test '%s': got lines %v, expect %v", tst.Path, gotLines, tst.ExpectLines)
+		}
+	}
+}
+
+func TestXMLStreamMalformed(t *testing.T) {
+	x, err := NewXMLStream(strings.NewReader("<a>\n<b>1</b>\n<b>2</c>\n</a>"), DefaultXMLRecordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := x.Next(); err != nil || !reflect.DeepEqual(rec, Object{"#text": "1"}) {
+		t.Errorf("first record: got %v, %v", rec, err)
+	}
+	if _, err := x.Next(); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("second record: expected syntax error on line 3, got %v", err)
+	}
+}
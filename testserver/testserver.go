@@ -51,7 +51,7 @@ var rootCmd = cobra.Command{
 	Use:   "testserver [bindaddr]",
 	Short: "a simple HTTP server for testing",
 	Long: "a simple HTTP server for testing.\n\n" +
-		"When -s is used for HTTPS mode, use certificate 'testserver/key.pem' to trust the server.",
+		"When -s is used for HTTPS mode, use certificate 'testserver/server.pem' to trust the server (e.g. hs --cacert).",
 	Args: cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) > 0 {